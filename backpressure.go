@@ -0,0 +1,69 @@
+package goreactor
+
+// backpressureWatcher pauses reading of upstream when the output buffer of the
+// downstream connection is too large, it lives in the loop of downstream
+type backpressureWatcher struct {
+	upstream TCPConnection
+
+	highWater int
+	lowWater  int
+
+	// whether the watcher paused upstream
+	paused bool
+}
+
+func (conn *tcpConnection) SetBackpressure(downstream TCPConnection, highWater int, lowWater int) {
+	// the output buffer of a TLS connection is the one of its TCP connection
+	d := downstream.underlying()
+
+	if highWater < 0 || lowWater < 0 || (highWater != 0 && lowWater >= highWater) {
+		panic("lowWater must be less than highWater")
+	}
+
+	d.loop.RunInLoop(func() {
+		for i, w := range d.backpressureWatchers {
+			if w.upstream == TCPConnection(conn) {
+				if w.paused {
+					conn.ResumeRead()
+				}
+				d.backpressureWatchers = append(d.backpressureWatchers[:i], d.backpressureWatchers[i+1:]...)
+				break
+			}
+		}
+
+		if highWater == 0 || d.state == Disconnected {
+			return
+		}
+
+		d.backpressureWatchers = append(d.backpressureWatchers, &backpressureWatcher{
+			upstream:  conn,
+			highWater: highWater,
+			lowWater:  lowWater,
+		})
+		d.checkBackpressure()
+	})
+}
+
+// be called in loop goroutine after the output queue is changed
+func (conn *tcpConnection) checkBackpressure() {
+	sz := conn.bufferedBytes()
+	for _, w := range conn.backpressureWatchers {
+		if !w.paused && sz > w.highWater {
+			w.paused = true
+			w.upstream.PauseRead()
+		} else if w.paused && sz <= w.lowWater {
+			w.paused = false
+			w.upstream.ResumeRead()
+		}
+	}
+}
+
+// the connection is closed, upstreams should not be paused forever
+func (conn *tcpConnection) releaseBackpressure() {
+	for _, w := range conn.backpressureWatchers {
+		if w.paused {
+			w.upstream.ResumeRead()
+		}
+	}
+	conn.backpressureWatchers = nil
+}
@@ -0,0 +1,31 @@
+#!/bin/sh
+# compare the single base-loop acceptor with per-loop SO_REUSEPORT acceptors,
+# and level-triggered connections with edge-triggered ones
+# usage: ./bench.sh [connections] [seconds] [message length]
+
+cd "$(dirname "$0")/.." || exit 1
+
+CONNS=${1:-1000}
+SECONDS_=${2:-10}
+MSGLEN=${3:-1024}
+
+go build -o /tmp/goreactor-server ./goreactor-server || exit 1
+go build -o /tmp/echo-client ./echo-client || exit 1
+
+run() {
+	echo "--- $* ---"
+	/tmp/goreactor-server "$@" &
+	pid=$!
+	sleep 1
+	/tmp/echo-client -c "$CONNS" -t "$SECONDS_" -m "$MSGLEN"
+	kill $pid
+	wait $pid 2>/dev/null
+}
+
+for mode in false true; do
+	run -reuseport=$mode
+done
+
+for mode in false true; do
+	run -et=$mode
+done
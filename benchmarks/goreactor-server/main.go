@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"runtime"
 
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
@@ -10,9 +11,23 @@ import (
 	goreactor "github.com/markity/go-reactor"
 )
 
+var addr = flag.String("a", "127.0.0.1:8000", "address")
+var loops = flag.Int("loops", runtime.NumCPU(), "num of working loops")
+var reusePort = flag.Bool("reuseport", false, "each working loop has its own SO_REUSEPORT acceptor")
+var edgeTriggered = flag.Bool("et", false, "register connections with EPOLLET")
+
 func main() {
+	flag.Parse()
+
 	evloop := eventloop.NewEventLoop()
-	server := goreactor.NewTCPServer(evloop, "127.0.0.1:8000", runtime.NumCPU(), goreactor.RoundRobin())
+
+	var server goreactor.TCPServer
+	if *reusePort {
+		server = goreactor.NewReusePortTCPServer(evloop, *addr, *loops)
+	} else {
+		server = goreactor.NewTCPServer(evloop, *addr, *loops, goreactor.RoundRobin())
+	}
+	server.SetEdgeTriggered(*edgeTriggered)
 	server.SetMessageCallback(func(t goreactor.TCPConnection, b buffer.Buffer) {
 		t.Send(b.Peek())
 		b.RetrieveAll()
@@ -0,0 +1,35 @@
+#!/bin/sh
+# count the system calls of the echo server built at a base git ref and at the
+# working tree, for example to see the epoll_ctl calls saved by the direct write
+# of Send. strace is required
+# usage: ./syscalls.sh <base ref> [connections] [seconds] [message length]
+
+cd "$(dirname "$0")/.." || exit 1
+
+BASE=${1:?usage: $0 <base ref> [connections] [seconds] [message length]}
+CONNS=${2:-100}
+SECONDS_=${3:-5}
+MSGLEN=${4:-1024}
+
+worktree=$(mktemp -d)
+git worktree add --detach "$worktree" "$BASE" >/dev/null || exit 1
+trap 'git worktree remove --force "$worktree"' EXIT
+
+(cd "$worktree/benchmarks" && go build -o /tmp/goreactor-server-base ./goreactor-server) || exit 1
+go build -o /tmp/goreactor-server ./goreactor-server || exit 1
+go build -o /tmp/echo-client ./echo-client || exit 1
+
+run() {
+	echo "--- $1 ---"
+	strace -f -c -e trace=read,readv,write,writev,epoll_ctl,epoll_wait,epoll_pwait \
+		-o /tmp/goreactor-strace.txt "$1" &
+	pid=$!
+	sleep 1
+	/tmp/echo-client -c "$CONNS" -t "$SECONDS_" -m "$MSGLEN"
+	kill -INT $pid
+	wait $pid 2>/dev/null
+	cat /tmp/goreactor-strace.txt
+}
+
+run /tmp/goreactor-server-base
+run /tmp/goreactor-server
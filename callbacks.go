@@ -1,17 +1,31 @@
 package goreactor
 
-import "github.com/markity/go-reactor/pkg/buffer"
+import (
+	"net/netip"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
 
 type ConnectedCallbackFunc func(TCPConnection)
 type DisConnectedCallbackFunc func(TCPConnection)
 type MessageCallbackFunc func(TCPConnection, buffer.Buffer)
+type FrameCallbackFunc func(TCPConnection, []byte)
 type HighWaterCallbackFunc func(TCPConnection, int)
+type LowWaterCallbackFunc func(TCPConnection, int)
 type WriteCompleteCallbackFunc func(TCPConnection)
+type PeerClosedWriteCallbackFunc func(TCPConnection)
+type ConnectFailedCallbackFunc func(TCPClient, error)
+type UDPMessageCallbackFunc func(UDPEndpoint, []byte, netip.AddrPort)
+type AcceptErrorCallbackFunc func(error)
 
 func defaultHighWaterMarkCallback(tc TCPConnection, sz int) {
 	// just do nothing
 }
 
+func defaultLowWaterMarkCallback(tc TCPConnection, sz int) {
+	// just do nothing
+}
+
 func defaultWriteCompleteCallback(tc TCPConnection) {
 	// just do nothing
 }
@@ -27,3 +41,15 @@ func defaultConnectedCallback(tc TCPConnection) {
 func defaultMessageCallback(tc TCPConnection, buf buffer.Buffer) {
 	buf.RetrieveAsString()
 }
+
+func defaultConnectFailedCallback(tc TCPClient, err error) {
+	// just do nothing
+}
+
+func defaultUDPMessageCallback(ep UDPEndpoint, payload []byte, from netip.AddrPort) {
+	// just do nothing
+}
+
+func defaultAcceptErrorCallback(err error) {
+	// just do nothing
+}
@@ -9,6 +9,9 @@ import (
 type eventloopGoroutine struct {
 	started int64
 	loop    eventloop.EventLoop
+
+	// closed when Loop returns, DoOnStop has been called at that time
+	done chan struct{}
 }
 
 func (routine *eventloopGoroutine) startLoop() {
@@ -18,13 +21,21 @@ func (routine *eventloopGoroutine) startLoop() {
 
 	go func() {
 		routine.loop.Loop()
+		close(routine.done)
 	}()
 }
 
+// stop the loop and wait until Loop returns
+func (routine *eventloopGoroutine) stopLoop() {
+	routine.loop.Stop()
+	<-routine.done
+}
+
 func newEventLoopGoroutine() *eventloopGoroutine {
 	loop := eventloop.NewEventLoop()
 	return &eventloopGoroutine{
 		started: 0,
 		loop:    loop,
+		done:    make(chan struct{}),
 	}
 }
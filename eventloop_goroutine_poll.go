@@ -1,12 +1,17 @@
 package goreactor
 
 import (
+	"sync/atomic"
+
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
 )
 
 type eventloopGoroutinePoll struct {
 	numOfGoroutine int
-	started        int64
+
+	// 0: not started, 1: started, 2: stopped. stop is called by Shutdown, getNext
+	// is called in acceptor loop goroutines
+	started        atomic.Int32
 	loopGoroutines []*eventloopGoroutine
 	loops          []eventloop.EventLoop
 	baseLoop       eventloop.EventLoop
@@ -29,7 +34,6 @@ func newEventloopGoroutinePoll(baseLoop eventloop.EventLoop,
 
 	return &eventloopGoroutinePoll{
 		numOfGoroutine: numOfGoroutinePoll,
-		started:        0,
 		baseLoop:       baseLoop,
 		strategy:       strategy,
 		loopGoroutines: loopGoroutines,
@@ -38,19 +42,30 @@ func newEventloopGoroutinePoll(baseLoop eventloop.EventLoop,
 }
 
 func (poll *eventloopGoroutinePoll) start() {
-	if poll.started == 1 {
-		panic(poll.started)
+	if poll.started.Load() != 0 {
+		panic(poll.started.Load())
 	}
 
 	for _, loop := range poll.loopGoroutines {
 		loop.startLoop()
 	}
 
-	poll.started = 1
+	poll.started.Store(1)
+}
+
+// stop all loops and wait until they return, the base loop is not stopped
+func (poll *eventloopGoroutinePoll) stop() {
+	if !poll.started.CompareAndSwap(1, 2) {
+		panic("not started yet")
+	}
+
+	for _, loop := range poll.loopGoroutines {
+		loop.stopLoop()
+	}
 }
 
 func (poll *eventloopGoroutinePoll) getNext() eventloop.EventLoop {
-	if poll.started == 0 {
+	if poll.started.Load() != 1 {
 		panic("not started yet")
 	}
 
@@ -12,3 +12,6 @@ const MaxLoseHeartbeat = 5
 // 服务端的配置
 const ServerListenIP = "0.0.0.0"
 const ServerListenPort = 8080
+
+// 封包的最大字节数, 超过的连接会被关闭
+const MaxPacketSize = 64 * 1024
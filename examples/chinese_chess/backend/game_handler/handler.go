@@ -7,7 +7,6 @@ import (
 	goreactor "github.com/markity/go-reactor"
 	commsettings "github.com/markity/go-reactor/examples/chinese_chess/backend/common_settings"
 	"github.com/markity/go-reactor/examples/chinese_chess/backend/tools"
-	"github.com/markity/go-reactor/pkg/buffer"
 
 	commpackets "github.com/markity/go-reactor/examples/chinese_chess/backend/common_packets"
 )
@@ -93,16 +92,11 @@ func OnClose(c goreactor.TCPConnection) {
 	delete(ConnMap, connID)
 }
 
-func OnMessage(c goreactor.TCPConnection, buf buffer.Buffer) {
-	fmt.Println("on message")
+// 每个封包有四个字节的大端长度头, 由codec切分好再交给OnPacket
+func OnPacket(c goreactor.TCPConnection, packetBytes []byte) {
+	fmt.Println("on packet")
 	connID_, _ := c.GetContext("conn_id")
 	connID := connID_.(int)
-	packetBytes, ok := tools.IsBytesCompleteWith4BytesHeader(buf.Peek())
-	if !ok {
-		fmt.Println("not ok")
-		return
-	}
-	buf.Retrieve(len(packetBytes) + 4)
 
 	packIface := commpackets.ServerParse(packetBytes)
 	switch packet := packIface.(type) {
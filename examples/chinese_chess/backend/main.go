@@ -7,6 +7,7 @@ import (
 	goreactor "github.com/markity/go-reactor"
 	commsettings "github.com/markity/go-reactor/examples/chinese_chess/backend/common_settings"
 	gamehandler "github.com/markity/go-reactor/examples/chinese_chess/backend/game_handler"
+	"github.com/markity/go-reactor/pkg/codec"
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
 )
 
@@ -18,7 +19,8 @@ func main() {
 	fmt.Println(listenIPPort)
 	server := goreactor.NewTCPServer(loop, listenIPPort, 0, goreactor.RoundRobin())
 	server.SetConnectionCallback(gamehandler.OnConnect)
-	server.SetMessageCallback(gamehandler.OnMessage)
+	server.SetFrameCallback(codec.NewLengthFieldDecoder(codec.LengthFieldConfig{Size: 4, Strip: 4}),
+		commsettings.MaxPacketSize, gamehandler.OnPacket)
 
 	err := server.Start()
 	if err != nil {
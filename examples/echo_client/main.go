@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+
+	goreactor "github.com/markity/go-reactor"
+)
+
+func main() {
+	loop := eventloop.NewEventLoop()
+
+	client := goreactor.NewTCPClient(loop, "127.0.0.1:8000", goreactor.TCPClientOptions{
+		Retry:                 true,
+		RetryInitialDelay:     time.Millisecond * 500,
+		RetryMaxDelay:         time.Second * 10,
+		ReconnectOnDisconnect: true,
+	})
+	client.SetConnectionCallback(func(t goreactor.TCPConnection) {
+		fmt.Println("connected to", t.GetRemoteAddrPort())
+		t.Send([]byte("hello"))
+	})
+	client.SetMessageCallback(func(t goreactor.TCPConnection, b buffer.Buffer) {
+		fmt.Println("echo:", b.RetrieveAsString())
+	})
+	client.SetDisConnectedCallback(func(t goreactor.TCPConnection) {
+		fmt.Println("disconnected, reconnecting")
+	})
+	client.SetConnectFailedCallback(func(c goreactor.TCPClient, err error) {
+		fmt.Println("connect failed:", err)
+	})
+
+	client.Connect()
+
+	loop.Loop()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	reactorhttp "github.com/markity/go-reactor/pkg/http"
+)
+
+// an http server at 127.0.0.1:8000, try it with curl:
+//
+//	curl http://127.0.0.1:8000/health
+//	curl -d 'hello' http://127.0.0.1:8000/echo
+//	curl http://127.0.0.1:8000/slow?ms=500
+func main() {
+	loop := eventloop.NewEventLoop()
+
+	tcpServer := goreactor.NewTCPServer(loop, "127.0.0.1:8000", 2, goreactor.RoundRobin())
+	tcpServer.SetDefaultIdleTimeout(time.Minute)
+
+	server := reactorhttp.NewServer(tcpServer)
+	server.SetMaxBodySize(64 * 1024)
+	server.SetHandler(func(w reactorhttp.ResponseWriter, r *reactorhttp.Request) {
+		switch r.Path {
+		case "/health":
+			w.Header().Set("Content-Type", "application/json")
+			bs, _ := json.Marshal(map[string]interface{}{
+				"status": "ok",
+				"remote": r.RemoteAddrPort().String(),
+			})
+			w.WriteResponse(200, bs)
+		case "/echo":
+			if r.Method != "POST" {
+				w.WriteResponse(405, []byte("method not allowed\n"))
+				return
+			}
+			w.WriteResponse(200, r.Body)
+		case "/slow":
+			// respond later in another goroutine, the loop is not blocked
+			d, err := time.ParseDuration(r.Query().Get("ms") + "ms")
+			if err != nil {
+				w.WriteResponse(400, []byte("bad ms\n"))
+				return
+			}
+			go func() {
+				time.Sleep(d)
+				w.WriteResponse(200, []byte("done\n"))
+			}()
+		default:
+			w.WriteResponse(404, []byte("not found\n"))
+		}
+	})
+
+	err := server.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	loop.Loop()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	"github.com/markity/go-reactor/pkg/metrics"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+
+	goreactor "github.com/markity/go-reactor"
+	asynclog "github.com/markity/go-reactor/pkg/async_log"
+)
+
+// an echo server at 127.0.0.1:8000, its metrics can be scraped at
+// http://127.0.0.1:9100/metrics
+func main() {
+	loop := eventloop.NewEventLoop()
+	logger := asynclog.NewLogger(asynclog.INFO, "/tmp/goreactor-metrics-example.log", 4, 1<<20)
+
+	server := goreactor.NewTCPServer(loop, "127.0.0.1:8000", 4, goreactor.RoundRobin())
+	server.SetConnectionCallback(func(t goreactor.TCPConnection) {
+		logger.Logf(asynclog.INFO, "connected %v", t.GetRemoteAddrPort())
+	})
+	server.SetMessageCallback(func(t goreactor.TCPConnection, b buffer.Buffer) {
+		t.Send([]byte(b.RetrieveAsString()))
+	})
+	if err := server.Start(); err != nil {
+		panic(err)
+	}
+
+	exporter := metrics.NewExporter(loop, "127.0.0.1:9100", server, logger)
+	if err := exporter.Start(); err != nil {
+		panic(err)
+	}
+
+	loop.Loop()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	goreactor "github.com/markity/go-reactor"
+	asynclog "github.com/markity/go-reactor/pkg/async_log"
+	"github.com/markity/go-reactor/pkg/codec"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	"github.com/markity/go-reactor/pkg/pipeline"
+)
+
+// upper converts inbound lines to upper case
+type upper struct{}
+
+func (upper) HandleRead(ctx *pipeline.Context, msg interface{}) {
+	ctx.FireRead(bytes.ToUpper(msg.([]byte)))
+}
+
+// echo replies each line with its number, "LOG ON" and "LOG OFF" add and remove
+// the logging handler at runtime
+type echo struct {
+	logger asynclog.Logger
+}
+
+func (e *echo) HandleAdded(ctx *pipeline.Context) {
+	ctx.SetValue(0)
+}
+
+func (e *echo) HandleRemoved(ctx *pipeline.Context) {}
+
+func (e *echo) HandleRead(ctx *pipeline.Context, msg interface{}) {
+	line := msg.([]byte)
+	n := ctx.Value().(int) + 1
+	ctx.SetValue(n)
+
+	p := ctx.Pipeline()
+	switch string(line) {
+	case "LOG ON":
+		if p.Get("log") == nil {
+			p.AddFirst("log", pipeline.NewLoggingHandler(e.logger, asynclog.INFO))
+		}
+	case "LOG OFF":
+		p.Remove("log")
+	}
+	ctx.Write([]byte(fmt.Sprintf("%d: %s", n, line)))
+}
+
+// a line echo server at 127.0.0.1:8000, try it with nc
+func main() {
+	loop := eventloop.NewEventLoop()
+	logger := asynclog.NewLogger(asynclog.INFO, "/tmp/goreactor-pipeline-example.log", 4, 1<<20)
+
+	server := goreactor.NewTCPServer(loop, "127.0.0.1:8000", 2, goreactor.RoundRobin())
+	server.SetConnectionCallback(func(t goreactor.TCPConnection) {
+		pipeline.New(t).
+			AddLast("line", pipeline.NewFrameCodecHandler(codec.NewLineDecoder(), codec.NewLineEncoder(), 4096)).
+			AddLast("upper", upper{}).
+			AddLast("echo", &echo{logger: logger})
+	})
+	server.SetMessageCallback(pipeline.MessageCallback)
+
+	if err := server.Start(); err != nil {
+		panic(err)
+	}
+	loop.Loop()
+}
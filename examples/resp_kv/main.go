@@ -0,0 +1,136 @@
+package main
+
+import (
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	"github.com/markity/go-reactor/pkg/resp"
+)
+
+type entry struct {
+	value []byte
+
+	// 0 means no expiry
+	expireTimerID int
+	expireAt      time.Time
+}
+
+// a key-value store at 127.0.0.1:6380 supporting GET, SET, DEL, EXPIRE and TTL,
+// try it with redis-cli -p 6380. there is no working loop, so the map is only
+// accessed in the base loop and needs no lock, keys expire by loop timers
+func main() {
+	loop := eventloop.NewEventLoop()
+	data := make(map[string]*entry)
+
+	del := func(key string) bool {
+		e, ok := data[key]
+		if !ok {
+			return false
+		}
+		if e.expireTimerID != 0 {
+			loop.CancelTimer(e.expireTimerID)
+		}
+		delete(data, key)
+		return true
+	}
+
+	server := resp.NewServer(goreactor.NewTCPServer(loop, "127.0.0.1:6380", 0, goreactor.RoundRobin()))
+
+	server.HandleFunc("GET", func(w resp.ReplyWriter, cmd *resp.Command) {
+		if len(cmd.Args) != 1 {
+			w.Reply(resp.WrongNumberOfArgs(cmd))
+			return
+		}
+		e, ok := data[string(cmd.Args[0])]
+		if !ok {
+			w.Reply(resp.Null())
+			return
+		}
+		w.Reply(resp.BulkString(e.value))
+	})
+
+	// SET key value, the expiry of the key is cleared
+	server.HandleFunc("SET", func(w resp.ReplyWriter, cmd *resp.Command) {
+		if len(cmd.Args) != 2 {
+			w.Reply(resp.WrongNumberOfArgs(cmd))
+			return
+		}
+		key := string(cmd.Args[0])
+		del(key)
+		data[key] = &entry{value: cmd.Args[1]}
+		w.Reply(resp.SimpleString("OK"))
+	})
+
+	server.HandleFunc("DEL", func(w resp.ReplyWriter, cmd *resp.Command) {
+		if len(cmd.Args) == 0 {
+			w.Reply(resp.WrongNumberOfArgs(cmd))
+			return
+		}
+		n := 0
+		for _, key := range cmd.Args {
+			if del(string(key)) {
+				n++
+			}
+		}
+		w.Reply(resp.Integer(int64(n)))
+	})
+
+	// EXPIRE key seconds
+	server.HandleFunc("EXPIRE", func(w resp.ReplyWriter, cmd *resp.Command) {
+		if len(cmd.Args) != 2 {
+			w.Reply(resp.WrongNumberOfArgs(cmd))
+			return
+		}
+		seconds, ok := resp.ParseInt(cmd.Args[1])
+		if !ok {
+			w.Reply(resp.Error("ERR value is not an integer or out of range"))
+			return
+		}
+		key := string(cmd.Args[0])
+		e, ok := data[key]
+		if !ok {
+			w.Reply(resp.Integer(0))
+			return
+		}
+		if seconds <= 0 {
+			del(key)
+			w.Reply(resp.Integer(1))
+			return
+		}
+
+		if e.expireTimerID != 0 {
+			loop.CancelTimer(e.expireTimerID)
+		}
+		e.expireAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		e.expireTimerID = loop.RunAt(e.expireAt, 0, func(int) {
+			e.expireTimerID = 0
+			del(key)
+		})
+		w.Reply(resp.Integer(1))
+	})
+
+	// -2 if the key does not exist, -1 if it has no expiry
+	server.HandleFunc("TTL", func(w resp.ReplyWriter, cmd *resp.Command) {
+		if len(cmd.Args) != 1 {
+			w.Reply(resp.WrongNumberOfArgs(cmd))
+			return
+		}
+		e, ok := data[string(cmd.Args[0])]
+		switch {
+		case !ok:
+			w.Reply(resp.Integer(-2))
+		case e.expireTimerID == 0:
+			w.Reply(resp.Integer(-1))
+		default:
+			w.Reply(resp.Integer(int64(time.Until(e.expireAt).Round(time.Second) / time.Second)))
+		}
+	})
+
+	err := server.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	loop.Loop()
+}
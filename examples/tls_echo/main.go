@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+	reactortls "github.com/markity/go-reactor/pkg/tls"
+
+	goreactor "github.com/markity/go-reactor"
+)
+
+// try it with: openssl s_client -connect 127.0.0.1:8443 -alpn echo
+func main() {
+	loop := eventloop.NewEventLoop()
+
+	server := reactortls.NewTLSServer(goreactor.NewTCPServer(loop, "127.0.0.1:8443", 0, goreactor.RoundRobin()),
+		&tls.Config{
+			Certificates: []tls.Certificate{selfSignedCert()},
+			NextProtos:   []string{"echo"},
+		})
+	server.SetHandshakeCompleteCallback(func(t reactortls.TLSConnection) {
+		fmt.Println("handshake complete, sni:", t.GetServerName(), "alpn:", t.GetNegotiatedProtocol())
+	})
+	server.SetMessageCallback(func(t goreactor.TCPConnection, b buffer.Buffer) {
+		t.Send([]byte(b.RetrieveAsString()))
+	})
+	err := server.Start()
+	if err != nil {
+		panic(err)
+	}
+	loop.Loop()
+}
+
+func selfSignedCert() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/netip"
+	"runtime"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+
+	goreactor "github.com/markity/go-reactor"
+)
+
+func main() {
+	loop := eventloop.NewEventLoop()
+
+	server := goreactor.NewUDPServer(loop, "127.0.0.1:8000", runtime.NumCPU())
+	server.SetMessageCallback(func(ep goreactor.UDPEndpoint, payload []byte, from netip.AddrPort) {
+		ep.SendTo(payload, from)
+	})
+	err := server.Start()
+	if err != nil {
+		panic(err)
+	}
+	loop.Loop()
+}
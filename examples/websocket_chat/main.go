@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	goreactor "github.com/markity/go-reactor"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	reactorhttp "github.com/markity/go-reactor/pkg/http"
+	"github.com/markity/go-reactor/pkg/websocket"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<body>
+<pre id="log"></pre>
+<input id="input" autofocus>
+<script>
+const ws = new WebSocket("ws://" + location.host + "/ws");
+const log = document.getElementById("log");
+ws.onmessage = e => { log.textContent += e.data + "\n"; };
+ws.onclose = e => { log.textContent += "closed: " + e.code + "\n"; };
+document.getElementById("input").onkeydown = e => {
+	if (e.key === "Enter") { ws.send(e.target.value); e.target.value = ""; }
+};
+</script>
+</body>
+</html>
+`
+
+// a chat room at http://127.0.0.1:8000, open it in several browser tabs
+func main() {
+	loop := eventloop.NewEventLoop()
+
+	// connections live in different loops, SendText can be called in any goroutine
+	var mu sync.Mutex
+	members := make(map[websocket.Conn]struct{})
+	broadcast := func(msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for c := range members {
+			c.SendText(msg)
+		}
+	}
+
+	ws := websocket.NewServer()
+	ws.SetConnectedCallback(func(c websocket.Conn) {
+		mu.Lock()
+		members[c] = struct{}{}
+		mu.Unlock()
+		broadcast(fmt.Sprintf("%v joined", c.TCPConnection().GetRemoteAddrPort()))
+	})
+	ws.SetMessageCallback(func(c websocket.Conn, typ websocket.MessageType, data []byte) {
+		if typ != websocket.TextMessage {
+			c.Close(websocket.CloseUnsupportedData, "text only")
+			return
+		}
+		broadcast(fmt.Sprintf("%v: %s", c.TCPConnection().GetRemoteAddrPort(), data))
+	})
+	ws.SetClosedCallback(func(c websocket.Conn, code int, reason string) {
+		mu.Lock()
+		delete(members, c)
+		mu.Unlock()
+		broadcast(fmt.Sprintf("%v left (%d)", c.TCPConnection().GetRemoteAddrPort(), code))
+	})
+
+	server := reactorhttp.NewServer(goreactor.NewTCPServer(loop, "127.0.0.1:8000", 2, goreactor.RoundRobin()))
+	server.SetHandler(func(w reactorhttp.ResponseWriter, r *reactorhttp.Request) {
+		switch r.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteResponse(200, []byte(page))
+		case "/ws":
+			ws.Upgrade(w, r)
+		default:
+			w.WriteResponse(404, []byte("not found\n"))
+		}
+	})
+
+	err := server.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	loop.Loop()
+}
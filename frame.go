@@ -0,0 +1,38 @@
+package goreactor
+
+import (
+	"github.com/markity/go-reactor/pkg/buffer"
+	"github.com/markity/go-reactor/pkg/codec"
+)
+
+// returns a message callback which calls f for each whole frame decoded by dec.
+// if dec fails, for example a frame is larger than maxFrameSize, the connection
+// is closed with DisconnectFrameError and the error is set as the last error.
+// maxFrameSize is 0 means infinite. it can be used by TCPClient too
+func NewFrameMessageCallback(dec codec.Decoder, maxFrameSize int, f FrameCallbackFunc) MessageCallbackFunc {
+	if maxFrameSize < 0 {
+		panic(maxFrameSize)
+	}
+
+	return func(conn TCPConnection, buf buffer.Buffer) {
+		// wrappers like reactortls.TLSConnection are closed by their connections
+		tc := conn.underlying()
+		for {
+			// f may close the connection
+			if tc.state == Disconnected {
+				return
+			}
+
+			frame, err := dec.Decode(buf, maxFrameSize)
+			if err != nil {
+				tc.lastError = err
+				tc.handleClose(DisconnectFrameError)
+				return
+			}
+			if frame == nil {
+				return
+			}
+			f(conn, frame)
+		}
+	}
+}
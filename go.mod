@@ -1,11 +1,12 @@
 module github.com/markity/go-reactor
 
-go 1.21.1
+go 1.23
 
 require (
 	github.com/Allenxuxu/gev v0.5.0
 	github.com/cloudwego/netpoll v0.6.0
 	github.com/markity/Interactive-Console v0.0.0-20230622112502-6658419229ed
+	github.com/petermattis/goid v0.0.0-20240503122002-4b96552b8156
 	github.com/tidwall/evio v1.0.8
 )
 
@@ -20,7 +21,6 @@ require (
 	github.com/libp2p/go-reuseport v0.0.1 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
-	github.com/petermattis/goid v0.0.0-20240503122002-4b96552b8156 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	golang.org/x/sys v0.19.0 // indirect
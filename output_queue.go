@@ -0,0 +1,186 @@
+package goreactor
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// writev(2) accepts at most IOV_MAX iovecs
+const iovMax = 1024
+
+// an element of the output queue, bytes copied by Send, slices of SendOwned and
+// SendV, or a file segment of SendFile
+type outputSegment struct {
+	// bytes copied by Send, consecutive Sends are appended to the same buffer
+	buf buffer.Buffer
+
+	// slice owned by the connection, the sent part is sliced off
+	data []byte
+
+	// duplicated from the file passed to SendFile, -1 if it is not a file
+	// segment. closed when the segment is sent or the connection is closed
+	fd        int
+	offset    int64
+	remaining int64
+}
+
+func (seg *outputSegment) isFile() bool {
+	return seg.fd >= 0
+}
+
+// bytes in memory, 0 for file segments
+func (seg *outputSegment) size() int {
+	if seg.buf != nil {
+		return seg.buf.ReadableBytes()
+	}
+	return len(seg.data)
+}
+
+// the buffer where Send appends to, a new one is queued if the last segment is
+// not a buffer
+func (conn *tcpConnection) tailBuffer() buffer.Buffer {
+	if n := len(conn.outputQueue); n != 0 && conn.outputQueue[n-1].buf != nil {
+		return conn.outputQueue[n-1].buf
+	}
+
+	buf := conn.spareBuffer
+	conn.spareBuffer = nil
+	if buf == nil {
+		buf = buffer.NewBuffer()
+	}
+	conn.outputQueue = append(conn.outputQueue, &outputSegment{buf: buf, fd: -1})
+	return buf
+}
+
+// queue a slice without copying
+func (conn *tcpConnection) queueOwned(bs []byte) {
+	conn.outputQueue = append(conn.outputQueue, &outputSegment{data: bs, fd: -1})
+}
+
+// bytes in memory waiting to be sent, file segments are not counted
+func (conn *tcpConnection) bufferedBytes() int {
+	sz := 0
+	for _, seg := range conn.outputQueue {
+		sz += seg.size()
+	}
+	return sz
+}
+
+func (conn *tcpConnection) hasPendingOutput() bool {
+	return len(conn.outputQueue) != 0
+}
+
+// write the memory segments at the head of the queue, at most iovMax segments
+// and limit bytes are written by one system call
+func (conn *tcpConnection) writeBuffered(limit int) (int, error) {
+	for _, seg := range conn.outputQueue {
+		if seg.isFile() || len(conn.iovecs) == iovMax || limit == 0 {
+			break
+		}
+
+		if seg.buf != nil {
+			limit -= conn.appendIovec(seg.buf.Peek(), limit)
+		} else {
+			limit -= conn.appendIovec(seg.data, limit)
+		}
+	}
+	return conn.flushIovecs()
+}
+
+// write the slices directly, see sendInLoop
+func (conn *tcpConnection) writeSlices(bss [][]byte, limit int) (int, error) {
+	for _, bs := range bss {
+		if len(conn.iovecs) == iovMax || limit == 0 {
+			break
+		}
+		limit -= conn.appendIovec(bs, limit)
+	}
+	return conn.flushIovecs()
+}
+
+// appends at most limit bytes of bs, returns the number of bytes appended
+func (conn *tcpConnection) appendIovec(bs []byte, limit int) int {
+	if len(bs) > limit {
+		bs = bs[:limit]
+	}
+	if len(bs) != 0 {
+		conn.iovecs = append(conn.iovecs, syscall.Iovec{Base: &bs[0], Len: uint64(len(bs))})
+	}
+	return len(bs)
+}
+
+// write(2) for a single iovec, writev(2) for more
+func (conn *tcpConnection) flushIovecs() (int, error) {
+	var n uintptr
+	var errno syscall.Errno
+	switch len(conn.iovecs) {
+	case 0:
+		return 0, nil
+	case 1:
+		n, _, errno = syscall.Syscall(syscall.SYS_WRITE, uintptr(conn.socketChannel.GetFD()),
+			uintptr(unsafe.Pointer(conn.iovecs[0].Base)), uintptr(conn.iovecs[0].Len))
+	default:
+		n, _, errno = syscall.Syscall(syscall.SYS_WRITEV, uintptr(conn.socketChannel.GetFD()),
+			uintptr(unsafe.Pointer(&conn.iovecs[0])), uintptr(len(conn.iovecs)))
+	}
+
+	// do not keep the slices alive
+	for i := range conn.iovecs {
+		conn.iovecs[i] = syscall.Iovec{}
+	}
+	conn.iovecs = conn.iovecs[:0]
+
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+// remove n written bytes from the memory segments at the head of the queue,
+// empty memory segments are removed too
+func (conn *tcpConnection) consumeOutput(n int) {
+	for len(conn.outputQueue) != 0 {
+		seg := conn.outputQueue[0]
+		if seg.isFile() {
+			return
+		}
+
+		k := seg.size()
+		if k > n {
+			k = n
+		}
+		n -= k
+		if seg.buf != nil {
+			seg.buf.Retrieve(k)
+		} else {
+			seg.data = seg.data[k:]
+		}
+		if seg.size() != 0 {
+			return
+		}
+
+		// keep one drained buffer, Send usually needs a new one soon
+		if seg.buf != nil {
+			seg.buf.RetrieveAll()
+			conn.spareBuffer = seg.buf
+		}
+		conn.popOutput()
+	}
+}
+
+func (conn *tcpConnection) popOutput() {
+	conn.outputQueue[0] = nil
+	conn.outputQueue = conn.outputQueue[1:]
+}
+
+// the connection is closed, close the duplicated fds and drop the slices
+func (conn *tcpConnection) releaseOutput() {
+	for _, seg := range conn.outputQueue {
+		if seg.isFile() {
+			syscall.Close(seg.fd)
+		}
+	}
+	conn.outputQueue = nil
+}
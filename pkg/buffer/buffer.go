@@ -54,7 +54,7 @@ func (buf *buffer) Append(bs []byte) {
 			newBytes := make([]byte, buf.writeIndex-buf.readIndex+len(bs)+8192)
 			sz := buf.writeIndex - buf.readIndex + len(bs)
 			copy(newBytes, buf.data[buf.readIndex:buf.writeIndex])
-			copy(newBytes[buf.writeIndex:], bs)
+			copy(newBytes[buf.writeIndex-buf.readIndex:], bs)
 			buf.data = newBytes
 			buf.readIndex = 0
 			buf.writeIndex = sz
@@ -62,33 +62,34 @@ func (buf *buffer) Append(bs []byte) {
 	}
 }
 
-func (buf *buffer) ReadFD(fd int, extrabuf []byte) int {
+// the error is the errno of readv(2), for example EAGAIN, 0 bytes and nil error
+// means end of file
+func (buf *buffer) ReadFD(fd int, extrabuf []byte) (int, error) {
 	writable := len(buf.data) - buf.writeIndex
-	if writable == 0 {
-		return 0
-	}
-	ptr := uintptr(unsafe.Pointer(&buf.data[buf.writeIndex]))
-	base := (*byte)(unsafe.Pointer(ptr))
 	iovec := [2]syscall.Iovec{
-		{
-			Base: base,
-			Len:  uint64(writable),
-		},
 		{
 			Base: (*byte)(unsafe.Pointer(&extrabuf[0])),
 			Len:  uint64(len(extrabuf)),
 		},
 	}
+	iovcnt := 1
+	if writable > 0 {
+		iovec[1] = iovec[0]
+		iovec[0] = syscall.Iovec{
+			Base: (*byte)(unsafe.Pointer(&buf.data[buf.writeIndex])),
+			Len:  uint64(writable),
+		}
+		iovcnt = 2
+	}
 
-	sz, _, _ := syscall.Syscall(syscall.SYS_READV, uintptr(fd), uintptr(unsafe.Pointer(&iovec)), 2)
-
-	size := int(sz)
-	if size < 0 {
-		size = 0
+	sz, _, errno := syscall.Syscall(syscall.SYS_READV, uintptr(fd), uintptr(unsafe.Pointer(&iovec)), uintptr(iovcnt))
+	if errno != 0 {
+		return 0, errno
 	}
 
+	size := int(sz)
 	if size == 0 {
-		return 0
+		return 0, nil
 	}
 
 	if size <= writable {
@@ -98,7 +99,7 @@ func (buf *buffer) ReadFD(fd int, extrabuf []byte) int {
 		buf.Append(extrabuf[:size-writable])
 	}
 
-	return size
+	return size, nil
 }
 
 func NewBuffer() Buffer {
@@ -116,5 +117,5 @@ type Buffer interface {
 	RetrieveAll()
 	RetrieveAsString() string
 	Append([]byte)
-	ReadFD(int, []byte) int
+	ReadFD(int, []byte) (int, error)
 }
@@ -0,0 +1,39 @@
+package codec
+
+import (
+	"errors"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+var (
+	// the frame is larger than the max frame size
+	ErrFrameTooLarge = errors.New("codec: frame too large")
+
+	// the bytes can not be decoded, for example a negative length
+	ErrMalformedFrame = errors.New("codec: malformed frame")
+)
+
+// Decoder splits a byte stream into frames
+type Decoder interface {
+	// returns the next frame and retrieves its bytes from buf, or nil and nil
+	// error if more bytes are needed. the frame is a copy, it can be kept after
+	// the call. maxFrameSize is the limit of the frame, 0 means infinite, it is
+	// checked before the whole frame is received if possible
+	Decode(buf buffer.Buffer, maxFrameSize int) ([]byte, error)
+}
+
+// Encoder is the opposite of Decoder
+type Encoder interface {
+	// returns the bytes to be sent in order, frame itself is not copied, it is
+	// usually one element of the result, see TCPConnection.SendV
+	Encode(frame []byte) ([][]byte, error)
+}
+
+// copy n bytes of buf from off, and retrieve off+n+skip bytes
+func take(buf buffer.Buffer, off int, n int, skip int) []byte {
+	frame := make([]byte, n)
+	copy(frame, buf.Peek()[off:off+n])
+	buf.Retrieve(off + n + skip)
+	return frame
+}
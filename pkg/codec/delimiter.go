@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+type delimiterDecoder struct {
+	delim []byte
+
+	// accept "\r\n" as well as "\n", see NewLineDecoder
+	line bool
+}
+
+type delimiterEncoder struct {
+	delim []byte
+}
+
+// frames end with delim, the delimiter is not included in decoded frames
+func NewDelimiterDecoder(delim []byte) Decoder {
+	if len(delim) == 0 {
+		panic("empty delimiter")
+	}
+	return &delimiterDecoder{delim: append([]byte(nil), delim...)}
+}
+
+// lines end with "\n" or "\r\n", the line ending is not included
+func NewLineDecoder() Decoder {
+	return &delimiterDecoder{delim: []byte("\n"), line: true}
+}
+
+// append delim to each frame
+func NewDelimiterEncoder(delim []byte) Encoder {
+	if len(delim) == 0 {
+		panic("empty delimiter")
+	}
+	return &delimiterEncoder{delim: append([]byte(nil), delim...)}
+}
+
+// append "\r\n" to each frame, it is accepted by NewLineDecoder
+func NewLineEncoder() Encoder {
+	return &delimiterEncoder{delim: []byte("\r\n")}
+}
+
+func (dec *delimiterDecoder) Decode(buf buffer.Buffer, maxFrameSize int) ([]byte, error) {
+	i := bytes.Index(buf.Peek(), dec.delim)
+	if i < 0 {
+		// the delimiter may be split, the frame is larger than max only if more
+		// bytes than max plus the delimiter are buffered
+		if maxFrameSize != 0 && buf.ReadableBytes() >= maxFrameSize+len(dec.delim)+1 {
+			return nil, ErrFrameTooLarge
+		}
+		return nil, nil
+	}
+
+	n, skip := i, len(dec.delim)
+	if dec.line && n > 0 && buf.Peek()[n-1] == '\r' {
+		n--
+		skip++
+	}
+	if maxFrameSize != 0 && n > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	return take(buf, 0, n, skip), nil
+}
+
+func (enc *delimiterEncoder) Encode(frame []byte) ([][]byte, error) {
+	return [][]byte{frame, enc.delim}, nil
+}
@@ -0,0 +1,44 @@
+package codec
+
+import "github.com/markity/go-reactor/pkg/buffer"
+
+type fixedLengthDecoder struct {
+	n int
+}
+
+type fixedLengthEncoder struct {
+	n int
+}
+
+// each frame is n bytes
+func NewFixedLengthDecoder(n int) Decoder {
+	if n <= 0 {
+		panic(n)
+	}
+	return &fixedLengthDecoder{n: n}
+}
+
+// frames must be n bytes, or ErrMalformedFrame is returned
+func NewFixedLengthEncoder(n int) Encoder {
+	if n <= 0 {
+		panic(n)
+	}
+	return &fixedLengthEncoder{n: n}
+}
+
+func (dec *fixedLengthDecoder) Decode(buf buffer.Buffer, maxFrameSize int) ([]byte, error) {
+	if maxFrameSize != 0 && dec.n > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	if buf.ReadableBytes() < dec.n {
+		return nil, nil
+	}
+	return take(buf, 0, dec.n, 0), nil
+}
+
+func (enc *fixedLengthEncoder) Encode(frame []byte) ([][]byte, error) {
+	if len(frame) != enc.n {
+		return nil, ErrMalformedFrame
+	}
+	return [][]byte{frame}, nil
+}
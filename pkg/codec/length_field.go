@@ -0,0 +1,126 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// LengthFieldConfig describes a frame with a length field in its header, like
+//
+//	| Offset bytes | length field, Size bytes | body |
+//
+// the body size is the value of the length field plus Adjustment, for example
+// Adjustment is -Size if the length field counts itself
+type LengthFieldConfig struct {
+	// bytes before the length field, they are part of the frame
+	Offset int
+
+	// 1, 2, 4 or 8
+	Size int
+
+	// binary.BigEndian or binary.LittleEndian, nil means big endian
+	ByteOrder binary.ByteOrder
+
+	Adjustment int
+
+	// bytes removed from the start of the decoded frame, for example Offset+Size
+	// makes the decoder return the body only. only be used by the decoder
+	Strip int
+}
+
+func (cfg *LengthFieldConfig) check() {
+	if cfg.Size != 1 && cfg.Size != 2 && cfg.Size != 4 && cfg.Size != 8 {
+		panic(cfg.Size)
+	}
+	if cfg.Offset < 0 || cfg.Strip < 0 {
+		panic("negative offset or strip")
+	}
+	if cfg.ByteOrder == nil {
+		cfg.ByteOrder = binary.BigEndian
+	}
+}
+
+type lengthFieldDecoder struct {
+	cfg LengthFieldConfig
+}
+
+type lengthFieldEncoder struct {
+	cfg LengthFieldConfig
+}
+
+func NewLengthFieldDecoder(cfg LengthFieldConfig) Decoder {
+	cfg.check()
+	return &lengthFieldDecoder{cfg: cfg}
+}
+
+// Offset must be 0, the header only contains the length field
+func NewLengthFieldEncoder(cfg LengthFieldConfig) Encoder {
+	cfg.check()
+	if cfg.Offset != 0 {
+		panic("offset is not supported by the encoder")
+	}
+	return &lengthFieldEncoder{cfg: cfg}
+}
+
+func (dec *lengthFieldDecoder) Decode(buf buffer.Buffer, maxFrameSize int) ([]byte, error) {
+	cfg := &dec.cfg
+	header := cfg.Offset + cfg.Size
+	if buf.ReadableBytes() < header {
+		return nil, nil
+	}
+
+	field := buf.Peek()[cfg.Offset:header]
+	var length uint64
+	switch cfg.Size {
+	case 1:
+		length = uint64(field[0])
+	case 2:
+		length = uint64(cfg.ByteOrder.Uint16(field))
+	case 4:
+		length = uint64(cfg.ByteOrder.Uint32(field))
+	case 8:
+		length = cfg.ByteOrder.Uint64(field)
+	}
+	if length > math.MaxInt32 {
+		return nil, ErrFrameTooLarge
+	}
+
+	body := int(length) + cfg.Adjustment
+	if body < 0 || header+body < cfg.Strip {
+		return nil, ErrMalformedFrame
+	}
+	if maxFrameSize != 0 && header+body-cfg.Strip > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	if buf.ReadableBytes() < header+body {
+		return nil, nil
+	}
+
+	return take(buf, cfg.Strip, header+body-cfg.Strip, 0), nil
+}
+
+func (enc *lengthFieldEncoder) Encode(frame []byte) ([][]byte, error) {
+	cfg := &enc.cfg
+	length := len(frame) - cfg.Adjustment
+	if length < 0 {
+		return nil, ErrMalformedFrame
+	}
+	if cfg.Size < 8 && uint64(length) >= 1<<(8*cfg.Size) {
+		return nil, ErrFrameTooLarge
+	}
+
+	header := make([]byte, cfg.Size)
+	switch cfg.Size {
+	case 1:
+		header[0] = byte(length)
+	case 2:
+		cfg.ByteOrder.PutUint16(header, uint16(length))
+	case 4:
+		cfg.ByteOrder.PutUint32(header, uint32(length))
+	case 8:
+		cfg.ByteOrder.PutUint64(header, uint64(length))
+	}
+	return [][]byte{header, frame}, nil
+}
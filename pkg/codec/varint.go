@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+type varintDecoder struct{}
+
+type varintEncoder struct{}
+
+// frames are prefixed with their size in unsigned varint, like the length
+// delimited messages of protobuf, see binary.Uvarint
+func NewVarintDecoder() Decoder {
+	return varintDecoder{}
+}
+
+func NewVarintEncoder() Encoder {
+	return varintEncoder{}
+}
+
+func (varintDecoder) Decode(buf buffer.Buffer, maxFrameSize int) ([]byte, error) {
+	length, n := binary.Uvarint(buf.Peek())
+	if n == 0 {
+		// incomplete, a varint of uint64 is at most MaxVarintLen64 bytes
+		return nil, nil
+	}
+	if n < 0 || length > math.MaxInt32 {
+		return nil, ErrFrameTooLarge
+	}
+	if maxFrameSize != 0 && int(length) > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	if buf.ReadableBytes() < n+int(length) {
+		return nil, nil
+	}
+	return take(buf, n, int(length), 0), nil
+}
+
+func (varintEncoder) Encode(frame []byte) ([][]byte, error) {
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(len(frame)))
+	return [][]byte{header[:n], frame}, nil
+}
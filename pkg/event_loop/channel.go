@@ -12,6 +12,20 @@ const (
 	ReadableEvent ReactorEvent = syscall.EPOLLIN
 	WritableEvent ReactorEvent = syscall.EPOLLOUT
 	AllEvent      ReactorEvent = ReadableEvent | WritableEvent
+
+	// peer shutdown its write side, only reported if it is in events, see EnableReadHup
+	ReadHupEvent ReactorEvent = syscall.EPOLLRDHUP
+
+	// urgent data, reported as readable
+	PriorityEvent ReactorEvent = syscall.EPOLLPRI
+
+	// always reported by epoll even if they are not in events
+	HupEvent   ReactorEvent = syscall.EPOLLHUP
+	ErrorEvent ReactorEvent = syscall.EPOLLERR
+
+	// not an event, it makes the fd edge-triggered, see EPOLLET in epoll(7) and
+	// SetEdgeTriggered. syscall.EPOLLET is negative, so it is written as a bit
+	EdgeTriggeredEvent ReactorEvent = 1 << 31
 )
 
 type channel struct {
@@ -30,9 +44,14 @@ type channel struct {
 	// and poller will set a index for the channel
 	index int
 
+	// if it is true, EnableRead also registers ReadHupEvent
+	readHup bool
+
 	// callbacks
 	readCallback  func()
 	writeCallback func()
+	closeCallback func()
+	errorCallback func()
 }
 
 // some setters and getters
@@ -73,6 +92,41 @@ func (c *channel) SetWriteCallback(f func()) {
 	c.writeCallback = f
 }
 
+// called when HupEvent is reported without ReadableEvent, which means both
+// directions are closed and there is nothing to read
+func (c *channel) SetCloseCallback(f func()) {
+	c.closeCallback = f
+}
+
+// called when ErrorEvent is reported, the error can be fetched by SO_ERROR
+func (c *channel) SetErrorCallback(f func()) {
+	c.errorCallback = f
+}
+
+// make EnableRead register ReadHupEvent too, a peer half-close is reported as
+// readable, DisableRead removes both
+func (c *channel) EnableReadHup() {
+	c.readHup = true
+	if c.events&ReadableEvent != 0 {
+		c.events |= ReadHupEvent
+	}
+}
+
+// in edge-triggered mode, an event is reported only when the fd becomes ready,
+// the owner must read or write until EAGAIN. it is usually called before the
+// channel is registered, and interests are not changed after that
+func (c *channel) SetEdgeTriggered(et bool) {
+	if et {
+		c.events |= EdgeTriggeredEvent
+	} else {
+		c.events &= ^EdgeTriggeredEvent
+	}
+}
+
+func (c *channel) IsEdgeTriggered() bool {
+	return c.events&EdgeTriggeredEvent != 0
+}
+
 func (c *channel) IsWriting() bool {
 	return c.events&WritableEvent != 0
 }
@@ -112,6 +166,9 @@ func (c *channel) EnableRead() bool {
 	}
 
 	c.events |= ReadableEvent
+	if c.readHup {
+		c.events |= ReadHupEvent
+	}
 	return true
 }
 
@@ -121,15 +178,27 @@ func (c *channel) DisableRead() bool {
 		return false
 	}
 
-	c.events &= ^ReadableEvent
+	c.events &= ^(ReadableEvent | ReadHupEvent)
 	return true
 }
 
 // handle all events for the channel
 func (c *channel) HandleEvent() {
-	// TODO: EPOLLHUP
+	// with ReadableEvent, the remaining data should be read first, read(2)
+	// returns 0 after that
+	if c.revents&HupEvent != 0 && c.revents&ReadableEvent == 0 {
+		if c.closeCallback != nil {
+			c.closeCallback()
+		}
+	}
+
+	if c.revents&ErrorEvent != 0 {
+		if c.errorCallback != nil {
+			c.errorCallback()
+		}
+	}
 
-	if c.revents&ReadableEvent != 0 {
+	if c.revents&(ReadableEvent|PriorityEvent|ReadHupEvent) != 0 {
 		if c.readCallback != nil {
 			c.readCallback()
 		}
@@ -165,6 +234,13 @@ type Channel interface {
 
 	SetReadCallback(func())
 	SetWriteCallback(func())
+	SetCloseCallback(func())
+	SetErrorCallback(func())
+
+	EnableReadHup()
+
+	SetEdgeTriggered(bool)
+	IsEdgeTriggered() bool
 
 	HandleEvent()
 
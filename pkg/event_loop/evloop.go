@@ -24,10 +24,14 @@ type eventloop struct {
 	mu       sync.Mutex
 	functors []func()
 
+	// set when the loop is executing functors, only be accessed in loop goroutine
+	callingFunctors bool
+
 	// be used to stop eventloop, make eventloop.Loop returns
 	running int64
 
-	// gid is goroutine id, set when event loop calls Loop
+	// gid is goroutine id, set when event loop calls Loop, it is read by other
+	// goroutines to tell whether they run in the loop, so atomic is used
 	gid int64
 
 	// echo eventloop has a id
@@ -106,6 +110,11 @@ type EventLoop interface {
 	// queue a functor into eventloop, the function will be called latter in loop goroutine
 	RunInLoop(func())
 
+	// like RunInLoop, but the functor is always queued even in loop goroutine,
+	// it is called after the active channels are handled. it can be used to
+	// call user callbacks without recursion
+	QueueInLoop(func())
+
 	// stop eventloop and make Loop() return
 	Stop()
 
@@ -122,6 +131,13 @@ type EventLoop interface {
 	// get current channel count in this loop, it may be used for load balance
 	GetChannelCount() int
 
+	// functors queued by RunInLoop and QueueInLoop but not executed yet, it can
+	// be called in any goroutine
+	GetFunctorCount() int
+
+	// timers which are not triggered or cancelled yet, repeating timers included
+	GetTimerCount() int
+
 	// for go-reactor developers, this is be used to register channel into epollfd
 	// go-reacotr users can ignore functions below
 
@@ -196,7 +212,7 @@ func (ev *eventloop) Loop() {
 		panic("it is already running? don't run it again")
 	}
 
-	ev.gid = getGid()
+	atomic.StoreInt64(&ev.gid, getGid())
 
 	if ev.doOnLoop != nil {
 		ev.doOnLoop(ev)
@@ -219,18 +235,22 @@ func (ev *eventloop) Loop() {
 		ev.mu.Unlock()
 
 		// execute all functors
+		ev.callingFunctors = true
 		for _, v := range f {
 			v()
 		}
+		ev.callingFunctors = false
 	}
 
-	ev.doOnStop(ev)
+	if ev.doOnStop != nil {
+		ev.doOnStop(ev)
+	}
 }
 
 // queue a functor into a loop, func will be called in the loop goroutine later
 func (ev *eventloop) RunInLoop(f func()) {
 	// if is running and it is in eventloop goroutine, just execute it right now
-	if running := atomic.LoadInt64(&ev.running) == 1; running && ev.gid == getGid() {
+	if running := atomic.LoadInt64(&ev.running) == 1; running && atomic.LoadInt64(&ev.gid) == getGid() {
 		f()
 	} else {
 		// or queue the functor into ev.functors, the lock protects functors
@@ -243,6 +263,20 @@ func (ev *eventloop) RunInLoop(f func()) {
 	}
 }
 
+func (ev *eventloop) QueueInLoop(f func()) {
+	ev.mu.Lock()
+	ev.functors = append(ev.functors, f)
+	ev.mu.Unlock()
+
+	// in loop goroutine, functors are executed after the active channels are
+	// handled, there is no need to wake up epoll_wait unless they are being
+	// executed now
+	inLoop := atomic.LoadInt64(&ev.running) == 1 && atomic.LoadInt64(&ev.gid) == getGid()
+	if !inLoop || ev.callingFunctors {
+		ev.wakeup()
+	}
+}
+
 // stop a eventloop
 func (ev *eventloop) Stop() {
 	ev.RunInLoop(func() {
@@ -256,7 +290,7 @@ func (ev *eventloop) GetID() int {
 }
 
 func (ev *eventloop) GetChannelCount() int {
-	if getGid() == ev.gid {
+	if getGid() == atomic.LoadInt64(&ev.gid) {
 		return ev.poller.GetChannelCount()
 	}
 
@@ -267,11 +301,30 @@ func (ev *eventloop) GetChannelCount() int {
 	return <-c
 }
 
+func (ev *eventloop) GetFunctorCount() int {
+	ev.mu.Lock()
+	n := len(ev.functors)
+	ev.mu.Unlock()
+	return n
+}
+
+func (ev *eventloop) GetTimerCount() int {
+	if getGid() == atomic.LoadInt64(&ev.gid) {
+		return ev.timerQueue.heap.Len()
+	}
+
+	c := make(chan int, 1)
+	ev.RunInLoop(func() {
+		c <- ev.timerQueue.heap.Len()
+	})
+	return <-c
+}
+
 // setup a timer, returns its id, it can be cancelled, see CancelTimer(id int)
 func (ev *eventloop) RunAt(triggerAt time.Time, interval time.Duration, f func(timerID int)) int {
 	// ev.timerQueue can noly be operated in loop goroutine, we need to use RunInLoop
 	// and get its return value by golang channel
-	if getGid() == ev.gid {
+	if getGid() == atomic.LoadInt64(&ev.gid) {
 		return ev.timerQueue.AddTimer(triggerAt, interval, f)
 	}
 
@@ -284,7 +337,7 @@ func (ev *eventloop) RunAt(triggerAt time.Time, interval time.Duration, f func(t
 
 // cancel a timer
 func (ev *eventloop) CancelTimer(id int) bool {
-	if getGid() == ev.gid {
+	if getGid() == atomic.LoadInt64(&ev.gid) {
 		return ev.timerQueue.CancelTimer(id)
 	}
 
@@ -159,6 +159,8 @@ func (tq *timerQueue) CancelTimer(timerId int) bool {
 		newHeap = append(newHeap, tq.heap[i])
 	}
 	tq.heap = newHeap
+	// removing an entry breaks the heap order of entries after it
+	heap.Init(&tq.heap)
 	return ok
 }
 
@@ -187,7 +189,9 @@ func (tq *timerQueue) getExpired() []timerHeapEntry {
 
 	// reset the timerfd, set it to the earliest one
 	if tq.heap.Len() != 0 {
-		nsec := 0
+		// the earliest one may expire after now is taken above, a zero value would
+		// disarm the timer, see AddTimer
+		nsec := 1
 
 		now := time.Now()
 		earliest := tq.heap[0].TimeStamp
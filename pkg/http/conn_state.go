@@ -0,0 +1,404 @@
+package reactorhttp
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// connection context key of the parsing state
+const contextKey = "__http"
+
+// reading stops when so many requests are waiting for responses
+const maxPipelined = 16
+
+// a chunk size line or a trailer line longer than it is malformed
+const maxChunkLineSize = 4096
+
+type parseState int
+
+const (
+	stateHeader parseState = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateTrailer
+)
+
+// connState parses requests of a connection and sends responses in order, it is
+// only accessed in the loop goroutine
+type connState struct {
+	server *server
+	conn   goreactor.TCPConnection
+	buf    buffer.Buffer
+
+	state parseState
+
+	// the request whose body is being read
+	req *Request
+
+	// bytes left of the body or of the current chunk
+	remaining int
+
+	// responses in the order of requests
+	pending []*responseWriter
+
+	// no more requests are parsed, the connection is closed after pending
+	// responses are sent
+	closing bool
+	closed  bool
+
+	// an upgrade request is waiting for its response, requests after it are not
+	// parsed until it is responded without upgrading
+	waitingUpgrade bool
+
+	// not nil after the connection is upgraded
+	upgraded goreactor.MessageCallbackFunc
+
+	parsing    bool
+	readPaused bool
+}
+
+func (cs *connState) parse() {
+	cs.parsing = true
+	defer func() { cs.parsing = false }()
+
+	for !cs.closing && !cs.closed && !cs.waitingUpgrade && cs.upgraded == nil {
+		if len(cs.pending) >= maxPipelined {
+			if !cs.readPaused {
+				cs.readPaused = true
+				cs.conn.PauseRead()
+			}
+			return
+		}
+
+		var ok bool
+		switch cs.state {
+		case stateHeader:
+			ok = cs.parseHeader()
+		case stateBody:
+			ok = cs.parseBody()
+		case stateChunkSize:
+			ok = cs.parseChunkSize()
+		case stateChunkData:
+			ok = cs.parseChunkData()
+		case stateTrailer:
+			ok = cs.parseTrailer()
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// parse functions return false if more bytes are needed or the connection fails
+
+func (cs *connState) parseHeader() bool {
+	// empty lines before the request line should be ignored, see RFC 9112
+	for bytes.HasPrefix(cs.buf.Peek(), []byte("\r\n")) {
+		cs.buf.Retrieve(2)
+	}
+
+	data := cs.buf.Peek()
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) > cs.server.maxHeaderSize {
+			cs.fail(431)
+		}
+		return false
+	}
+	if end+4 > cs.server.maxHeaderSize {
+		cs.fail(431)
+		return false
+	}
+
+	req, status := parseRequestHead(string(data[:end]))
+	cs.buf.Retrieve(end + 4)
+	if status != 0 {
+		cs.fail(status)
+		return false
+	}
+	req.Conn = cs.conn
+
+	chunked := false
+	contentLength := 0
+	if te := req.Header.Values("Transfer-Encoding"); len(te) != 0 {
+		// a request with both is a request smuggling attempt, see RFC 9112
+		if len(req.Header.Values("Content-Length")) != 0 {
+			cs.fail(400)
+			return false
+		}
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			cs.fail(501)
+			return false
+		}
+		chunked = true
+	} else if cl := req.Header.Values("Content-Length"); len(cl) != 0 {
+		// it must be 1*DIGIT, strconv.Atoi accepts a sign
+		n, err := strconv.Atoi(cl[0])
+		if err != nil || !isDigits(cl[0]) {
+			cs.fail(400)
+			return false
+		}
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				cs.fail(400)
+				return false
+			}
+		}
+		if n > cs.server.maxBodySize {
+			cs.fail(413)
+			return false
+		}
+		contentLength = n
+	}
+
+	if !chunked && contentLength == 0 {
+		cs.dispatch(req)
+		return true
+	}
+
+	cs.req = req
+	if chunked {
+		cs.state = stateChunkSize
+	} else {
+		cs.state = stateBody
+		cs.remaining = contentLength
+	}
+
+	// the client waits for it before sending the body. it is queued like a
+	// response, so it is not sent before responses of previous requests
+	if req.Proto == "HTTP/1.1" && req.Header.HasToken("Expect", "100-continue") {
+		w := &responseWriter{cs: cs, req: req, header: Header{}}
+		w.responded.Store(true)
+		w.data = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+		cs.pending = append(cs.pending, w)
+		cs.flush()
+	}
+	return true
+}
+
+func (cs *connState) parseBody() bool {
+	if cs.buf.ReadableBytes() < cs.remaining {
+		return false
+	}
+
+	cs.req.Body = make([]byte, cs.remaining)
+	copy(cs.req.Body, cs.buf.Peek())
+	cs.buf.Retrieve(cs.remaining)
+	cs.dispatch(cs.req)
+	return true
+}
+
+func (cs *connState) parseChunkSize() bool {
+	line, ok := cs.readLine()
+	if !ok {
+		return false
+	}
+
+	// chunk extensions are ignored
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(line), 16, 31)
+	if err != nil {
+		cs.fail(400)
+		return false
+	}
+	if size == 0 {
+		cs.state = stateTrailer
+		return true
+	}
+	if len(cs.req.Body)+int(size) > cs.server.maxBodySize {
+		cs.fail(413)
+		return false
+	}
+
+	cs.remaining = int(size)
+	cs.state = stateChunkData
+	return true
+}
+
+func (cs *connState) parseChunkData() bool {
+	if cs.buf.ReadableBytes() < cs.remaining+2 {
+		return false
+	}
+
+	data := cs.buf.Peek()
+	if data[cs.remaining] != '\r' || data[cs.remaining+1] != '\n' {
+		cs.fail(400)
+		return false
+	}
+	cs.req.Body = append(cs.req.Body, data[:cs.remaining]...)
+	cs.buf.Retrieve(cs.remaining + 2)
+	cs.state = stateChunkSize
+	return true
+}
+
+// trailer fields are discarded
+func (cs *connState) parseTrailer() bool {
+	line, ok := cs.readLine()
+	if !ok {
+		return false
+	}
+
+	if line == "" {
+		if cs.req.Body == nil {
+			cs.req.Body = []byte{}
+		}
+		cs.dispatch(cs.req)
+	}
+	return true
+}
+
+// reads a line ending with CRLF, the connection fails if the line is too long
+func (cs *connState) readLine() (string, bool) {
+	data := cs.buf.Peek()
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) > maxChunkLineSize {
+			cs.fail(400)
+		}
+		return "", false
+	}
+
+	line := string(data[:end])
+	cs.buf.Retrieve(end + 2)
+	return line, true
+}
+
+func (cs *connState) dispatch(req *Request) {
+	cs.state = stateHeader
+	cs.req = nil
+	if req.Close {
+		cs.closing = true
+	}
+
+	w := &responseWriter{cs: cs, req: req, header: Header{}}
+	if req.Header.Get("Upgrade") != "" && req.Header.HasToken("Connection", "upgrade") {
+		w.upgradeRequested = true
+		cs.waitingUpgrade = true
+	}
+	cs.pending = append(cs.pending, w)
+	cs.server.handler(w, req)
+}
+
+// responds with status and closes the connection, requests before it are still
+// responded
+func (cs *connState) fail(status int) {
+	cs.closing = true
+	cs.buf.RetrieveAll()
+
+	req := &Request{Proto: "HTTP/1.1", Header: Header{}, Close: true, Conn: cs.conn}
+	w := &responseWriter{cs: cs, req: req, header: Header{}, closeAfter: true}
+	w.responded.Store(true)
+	w.data = serializeResponse(req, status, w.header, []byte(StatusText(status)+"\n"), true)
+	cs.pending = append(cs.pending, w)
+	cs.flush()
+}
+
+// sends responses which are ready and are not waiting for previous ones
+func (cs *connState) flush() {
+	if cs.closed {
+		return
+	}
+
+	for len(cs.pending) != 0 && cs.pending[0].data != nil {
+		w := cs.pending[0]
+		cs.pending[0] = nil
+		cs.pending = cs.pending[1:]
+		cs.conn.Send(w.data)
+
+		if w.upgrade != nil {
+			cs.upgraded = w.upgrade
+			cs.pending = nil
+			cs.upgraded(cs.conn, cs.buf)
+			return
+		}
+		if w.upgradeRequested {
+			cs.waitingUpgrade = false
+		}
+		if w.closeAfter {
+			cs.closing = true
+			cs.pending = nil
+			cs.conn.ShutdownWrite()
+			return
+		}
+	}
+
+	if cs.readPaused && len(cs.pending) < maxPipelined {
+		cs.readPaused = false
+		cs.conn.ResumeRead()
+	}
+
+	// requests left in the buffer while it was paused
+	if !cs.parsing {
+		cs.parse()
+	}
+}
+
+// parses the request line and header fields, status is not 0 if it is malformed
+func parseRequestHead(head string) (*Request, int) {
+	lines := strings.Split(head, "\r\n")
+
+	parts := strings.Split(lines[0], " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !isToken(parts[0]) {
+		return nil, 400
+	}
+	if parts[2] != "HTTP/1.1" && parts[2] != "HTTP/1.0" {
+		if strings.HasPrefix(parts[2], "HTTP/") {
+			return nil, 505
+		}
+		return nil, 400
+	}
+
+	req := &Request{
+		Method: parts[0],
+		Target: parts[1],
+		Path:   parts[1],
+		Proto:  parts[2],
+		Header: Header{},
+	}
+	if i := strings.IndexByte(req.Target, '?'); i >= 0 {
+		req.Path = req.Target[:i]
+		req.RawQuery = req.Target[i+1:]
+	}
+
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		// obsolete line folding is rejected, see RFC 9112
+		if i <= 0 || !isToken(line[:i]) {
+			return nil, 400
+		}
+		req.Header.Add(line[:i], strings.TrimSpace(line[i+1:]))
+	}
+
+	if req.Proto == "HTTP/1.1" {
+		req.Close = req.Header.HasToken("Connection", "close")
+	} else {
+		req.Close = !req.Header.HasToken("Connection", "keep-alive")
+	}
+	return req, 0
+}
+
+func isToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
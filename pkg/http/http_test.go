@@ -0,0 +1,176 @@
+package reactorhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// the default handler echoes the method, the path and the body
+func startServer(t *testing.T, setup func(Server)) string {
+	loop := eventloop.NewEventLoop()
+	server := NewServer(goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin()))
+	server.SetHandler(func(w ResponseWriter, r *Request) {
+		w.WriteResponse(200, []byte(r.Method+" "+r.Path+" "+string(r.Body)))
+	})
+	if setup != nil {
+		setup(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	return server.TCPServer().GetListenAddrPort().String()
+}
+
+func dial(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) (int, string) {
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// the server responds with status and closes the connection
+func expectFailure(t *testing.T, addr string, req string, status int) {
+	conn, r := dial(t, addr)
+	conn.Write([]byte(req))
+	if got, body := readResponse(t, r); got != status {
+		t.Fatalf("%q is responded with %d %q, want %d", req, got, body, status)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("%q: the connection is not closed, %v", req, err)
+	}
+}
+
+func TestSplitReads(t *testing.T) {
+	addr := startServer(t, nil)
+	conn, r := dial(t, addr)
+
+	req := "POST /split HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /chunked HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n2\r\nde\r\n0\r\nTrailer: x\r\n\r\n"
+	for i := 0; i < len(req); i++ {
+		conn.Write([]byte{req[i]})
+		time.Sleep(time.Millisecond)
+	}
+
+	if status, body := readResponse(t, r); status != 200 || body != "POST /split hello" {
+		t.Fatalf("got %d %q", status, body)
+	}
+	if status, body := readResponse(t, r); status != 200 || body != "POST /chunked abcde" {
+		t.Fatalf("got %d %q", status, body)
+	}
+}
+
+// responses are written in reverse order, they are sent in the order of requests
+func TestPipeliningOrder(t *testing.T) {
+	const n = 10
+	addr := startServer(t, func(s Server) {
+		s.SetHandler(func(w ResponseWriter, r *Request) {
+			i, _ := strconv.Atoi(strings.TrimPrefix(r.Path, "/"))
+			go func() {
+				time.Sleep(time.Duration(n-i) * 5 * time.Millisecond)
+				w.WriteResponse(200, []byte(r.Path))
+			}()
+		})
+	})
+	conn, r := dial(t, addr)
+
+	var req strings.Builder
+	for i := 0; i < n; i++ {
+		req.WriteString("GET /" + strconv.Itoa(i) + " HTTP/1.1\r\n\r\n")
+	}
+	conn.Write([]byte(req.String()))
+
+	for i := 0; i < n; i++ {
+		if _, body := readResponse(t, r); body != "/"+strconv.Itoa(i) {
+			t.Fatalf("response %d is for %s", i, body)
+		}
+	}
+}
+
+func TestRequestSmugglingIsRejected(t *testing.T) {
+	addr := startServer(t, nil)
+
+	expectFailure(t, addr, "POST / HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", 400)
+	expectFailure(t, addr, "POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd", 400)
+	for _, cl := range []string{"+5", "-1", "0x5", "5 5", "1_0", ""} {
+		expectFailure(t, addr, "POST / HTTP/1.1\r\nContent-Length: "+cl+"\r\n\r\nhello", 400)
+	}
+	expectFailure(t, addr, "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", 501)
+	expectFailure(t, addr, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n", 400)
+}
+
+// 100 Continue of the second request is not sent before the response of the
+// first one
+func TestExpectContinueOrder(t *testing.T) {
+	addr := startServer(t, func(s Server) {
+		s.SetHandler(func(w ResponseWriter, r *Request) {
+			if r.Method == "GET" {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					w.WriteResponse(200, []byte("first"))
+				}()
+				return
+			}
+			w.WriteResponse(200, r.Body)
+		})
+	})
+	conn, r := dial(t, addr)
+
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n" +
+		"POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 6\r\n\r\n"))
+	if status, body := readResponse(t, r); status != 200 || body != "first" {
+		t.Fatalf("got %d %q", status, body)
+	}
+	if status, _ := readResponse(t, r); status != 100 {
+		t.Fatalf("got %d, want 100", status)
+	}
+
+	conn.Write([]byte("second"))
+	if status, body := readResponse(t, r); status != 200 || body != "second" {
+		t.Fatalf("got %d %q", status, body)
+	}
+}
+
+func TestOversizedHeader(t *testing.T) {
+	addr := startServer(t, func(s Server) {
+		s.SetMaxHeaderSize(1024)
+	})
+
+	big := "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("x", 2048) + "\r\n\r\n"
+	expectFailure(t, addr, big, 431)
+	// the end of the header is not received yet
+	expectFailure(t, addr, big[:1500], 431)
+
+	conn, r := dial(t, addr)
+	conn.Write([]byte("GET /ok HTTP/1.1\r\nX-Small: " + strings.Repeat("x", 512) + "\r\n\r\n"))
+	if status, body := readResponse(t, r); status != 200 || body != "GET /ok " {
+		t.Fatalf("got %d %q", status, body)
+	}
+}
@@ -0,0 +1,83 @@
+package reactorhttp
+
+import (
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	goreactor "github.com/markity/go-reactor"
+)
+
+// Header maps canonical header names to values, see textproto.CanonicalMIMEHeaderKey
+type Header map[string][]string
+
+func (h Header) Get(key string) string {
+	v := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+func (h Header) Set(key string, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+func (h Header) Add(key string, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// true if one of the comma separated values of key is token, case insensitive,
+// for example HasToken("Connection", "upgrade")
+func (h Header) HasToken(key string, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Request struct {
+	Method string
+
+	// the request target of the request line, for example "/a?b=c"
+	Target   string
+	Path     string
+	RawQuery string
+
+	// "HTTP/1.0" or "HTTP/1.1"
+	Proto string
+
+	Header Header
+
+	// the whole body, chunked bodies are decoded
+	Body []byte
+
+	// the connection is closed after the response, see keep-alive in RFC 9112
+	Close bool
+
+	Conn goreactor.TCPConnection
+}
+
+func (r *Request) RemoteAddrPort() netip.AddrPort {
+	return r.Conn.GetRemoteAddrPort()
+}
+
+// the parsed query, invalid pairs are ignored
+func (r *Request) Query() url.Values {
+	v, _ := url.ParseQuery(r.RawQuery)
+	return v
+}
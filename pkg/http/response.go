@@ -0,0 +1,176 @@
+package reactorhttp
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+)
+
+type ResponseWriter interface {
+	// headers of the response, Content-Length, Connection and Date are set by
+	// WriteResponse
+	Header() Header
+
+	// write the whole response. it must be called exactly once for each request,
+	// in the handler or later in any goroutine, responses of pipelined requests
+	// are sent in the order of the requests
+	WriteResponse(status int, body []byte)
+
+	// respond 101 Switching Protocols and take over the connection, it is called
+	// instead of WriteResponse, in any goroutine. f is called in the loop goroutine
+	// once right after the response is sent, with bytes received after the
+	// request, and then for each message instead of parsing requests
+	Upgrade(f goreactor.MessageCallbackFunc)
+}
+
+type responseWriter struct {
+	cs     *connState
+	req    *Request
+	header Header
+
+	responded atomic.Bool
+
+	// fields below are only be accessed in loop goroutine
+
+	// the serialized response, nil if WriteResponse is not called yet
+	data []byte
+
+	// the connection is closed after the response is sent
+	closeAfter bool
+
+	// the request asks for an upgrade
+	upgradeRequested bool
+
+	// not nil if the connection is upgraded after the response is sent
+	upgrade goreactor.MessageCallbackFunc
+}
+
+func (w *responseWriter) Header() Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteResponse(status int, body []byte) {
+	if !w.responded.CompareAndSwap(false, true) {
+		panic("response is already written")
+	}
+
+	closeAfter := w.req.Close || w.header.HasToken("Connection", "close")
+	data := serializeResponse(w.req, status, w.header, body, closeAfter)
+	w.cs.conn.GetEventLoop().RunInLoop(func() {
+		w.data = data
+		w.closeAfter = closeAfter
+		w.cs.flush()
+	})
+}
+
+func (w *responseWriter) Upgrade(f goreactor.MessageCallbackFunc) {
+	if f == nil {
+		panic("nil callback")
+	}
+	if !w.responded.CompareAndSwap(false, true) {
+		panic("response is already written")
+	}
+
+	data := serializeResponse(w.req, 101, w.header, nil, false)
+	w.cs.conn.GetEventLoop().RunInLoop(func() {
+		w.data = data
+		w.upgrade = f
+		w.cs.flush()
+	})
+}
+
+// the Date header, see http.TimeFormat
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+func serializeResponse(req *Request, status int, header Header, body []byte, closeAfter bool) []byte {
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 ")
+	sb.WriteString(strconv.Itoa(status))
+	sb.WriteByte(' ')
+	sb.WriteString(StatusText(status))
+	sb.WriteString("\r\n")
+
+	header.Del("Content-Length")
+	header.Del("Connection")
+	header.Del("Transfer-Encoding")
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(timeFormat))
+	}
+
+	// 1xx, 204 and 304 responses have no body, see RFC 9110
+	noBody := status < 200 || status == 204 || status == 304
+	if !noBody {
+		if len(body) != 0 && header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if status == 101 {
+		header.Set("Connection", "Upgrade")
+	} else if closeAfter {
+		header.Set("Connection", "close")
+	} else if req.Proto == "HTTP/1.0" {
+		header.Set("Connection", "keep-alive")
+	}
+
+	for k, vs := range header {
+		for _, v := range vs {
+			sb.WriteString(k)
+			sb.WriteString(": ")
+			sb.WriteString(v)
+			sb.WriteString("\r\n")
+		}
+	}
+	sb.WriteString("\r\n")
+
+	if !noBody && req.Method != "HEAD" {
+		sb.Write(body)
+	}
+	return []byte(sb.String())
+}
+
+var statusText = map[int]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	408: "Request Timeout",
+	409: "Conflict",
+	411: "Length Required",
+	413: "Content Too Large",
+	415: "Unsupported Media Type",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+}
+
+// the reason phrase of the status code, "Status" if it is unknown
+func StatusText(status int) string {
+	if s, ok := statusText[status]; ok {
+		return s
+	}
+	return "Status"
+}
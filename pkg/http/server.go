@@ -0,0 +1,109 @@
+package reactorhttp
+
+import (
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// called in the loop goroutine of the connection for each request, it must not
+// block, see ResponseWriter for responding later
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// Server serves HTTP/1.1 on a TCPServer, a reactortls.TLSServer also works.
+// keep-alive, pipelining and upgrades are supported, trailers are discarded
+type Server interface {
+	// the default handler responds 404
+	SetHandler(h HandlerFunc)
+
+	// max size of the request line and header fields, 431 is responded if it is
+	// exceeded. the default is 8KB
+	SetMaxHeaderSize(n int)
+
+	// max size of a body, 413 is responded if it is exceeded. the default is 1MB
+	SetMaxBodySize(n int)
+
+	// start the TCPServer
+	Start() error
+
+	TCPServer() goreactor.TCPServer
+}
+
+type server struct {
+	tcpServer goreactor.TCPServer
+
+	handler       HandlerFunc
+	maxHeaderSize int
+	maxBodySize   int
+}
+
+// the connection callback and the message callback of tcpServer are taken over,
+// other methods like SetDefaultIdleTimeout and Shutdown can still be called
+func NewServer(tcpServer goreactor.TCPServer) Server {
+	s := &server{
+		tcpServer:     tcpServer,
+		handler:       defaultHandler,
+		maxHeaderSize: 8 * 1024,
+		maxBodySize:   1024 * 1024,
+	}
+	tcpServer.SetConnectionCallback(s.onConnection)
+	tcpServer.SetMessageCallback(s.onMessage)
+	return s
+}
+
+func (s *server) SetHandler(h HandlerFunc) {
+	if h == nil {
+		panic("nil handler")
+	}
+	s.handler = h
+}
+
+func (s *server) SetMaxHeaderSize(n int) {
+	if n <= 0 {
+		panic("invalid max header size")
+	}
+	s.maxHeaderSize = n
+}
+
+func (s *server) SetMaxBodySize(n int) {
+	if n < 0 {
+		panic("invalid max body size")
+	}
+	s.maxBodySize = n
+}
+
+func (s *server) Start() error {
+	return s.tcpServer.Start()
+}
+
+func (s *server) TCPServer() goreactor.TCPServer {
+	return s.tcpServer
+}
+
+func (s *server) onConnection(conn goreactor.TCPConnection) {
+	cs := &connState{server: s, conn: conn}
+	conn.SetContext(contextKey, cs)
+	conn.SetDisConnectedCallback(func(goreactor.TCPConnection) {
+		cs.closed = true
+		cs.pending = nil
+		cs.req = nil
+	})
+}
+
+func (s *server) onMessage(conn goreactor.TCPConnection, buf buffer.Buffer) {
+	cs := conn.MustGetContext(contextKey).(*connState)
+	if cs.upgraded != nil {
+		cs.upgraded(conn, buf)
+		return
+	}
+	if cs.closing {
+		buf.RetrieveAll()
+		return
+	}
+
+	cs.buf = buf
+	cs.parse()
+}
+
+func defaultHandler(w ResponseWriter, r *Request) {
+	w.WriteResponse(404, []byte("not found\n"))
+}
@@ -0,0 +1,314 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	asynclog "github.com/markity/go-reactor/pkg/async_log"
+	"github.com/markity/go-reactor/pkg/buffer"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// requests larger than it are rejected, a scrape request is usually small
+const maxRequestSize = 8192
+
+// loops which do not report their gauges in time are left out of the response,
+// a stopped or busy loop does not hold the scrape
+const collectTimeout = time.Second
+
+// the context key marking a connection whose request is being answered
+const respondingKey = "__metrics_responding"
+
+// Exporter serves the metrics of a TCPServer at /metrics in the Prometheus text
+// exposition format, with a TCPServer of its own, net/http is not used
+type Exporter interface {
+	// listen and serve, the loop passed to NewExporter must be running or be run
+	// later
+	Start() error
+}
+
+type exporter struct {
+	server goreactor.TCPServer
+	logger asynclog.Logger
+
+	loop       eventloop.EventLoop
+	httpServer goreactor.TCPServer
+
+	// the collection in progress, scrapes received meanwhile wait for it. only
+	// be used in loop goroutine
+	collecting *collection
+
+	// loops which have not reported the gauges asked by an earlier collection,
+	// they are not asked again, so a stopped loop does not pile up functors
+	probing map[int]bool
+}
+
+// gauges of a loop, read in its own loop goroutine
+type loopGauges struct {
+	id       int
+	channels int
+	functors int
+	timers   int
+}
+
+type collection struct {
+	conns []goreactor.TCPConnection
+
+	// in the order of loops, nil if the loop has not reported
+	gauges    []*loopGauges
+	remaining int
+	timerID   int
+}
+
+// addrPort is the address of the exporter, it must not be the address of server.
+// logger can be nil. the exporter runs in loop without working loops
+func NewExporter(loop eventloop.EventLoop, addrPort string, server goreactor.TCPServer,
+	logger asynclog.Logger) Exporter {
+	ex := &exporter{
+		server:     server,
+		logger:     logger,
+		loop:       loop,
+		probing:    make(map[int]bool),
+		httpServer: goreactor.NewTCPServer(loop, addrPort, 0, goreactor.RoundRobin()),
+	}
+	ex.httpServer.SetDefaultIdleTimeout(30 * time.Second)
+	ex.httpServer.SetMessageCallback(ex.onMessage)
+	return ex
+}
+
+func (ex *exporter) Start() error {
+	return ex.httpServer.Start()
+}
+
+func (ex *exporter) onMessage(conn goreactor.TCPConnection, buf buffer.Buffer) {
+	// one request each connection, the connection is closed after the response
+	if _, ok := conn.GetContext(respondingKey); ok {
+		buf.RetrieveAll()
+		return
+	}
+
+	end := bytes.Index(buf.Peek(), []byte("\r\n\r\n"))
+	if end < 0 {
+		if buf.ReadableBytes() > maxRequestSize {
+			conn.ForceClose()
+		}
+		return
+	}
+
+	line := buf.Peek()[:bytes.IndexByte(buf.Peek(), '\n')]
+	fields := bytes.Fields(line)
+	buf.RetrieveAll()
+	conn.SetContext(respondingKey, true)
+
+	if len(fields) != 3 || !bytes.HasPrefix(fields[2], []byte("HTTP/1.")) {
+		respond(conn, "400 Bad Request", "bad request\n")
+		return
+	}
+	if string(fields[0]) != "GET" {
+		respond(conn, "405 Method Not Allowed", "method not allowed\n")
+		return
+	}
+	path := fields[1]
+	if i := bytes.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if string(path) != "/metrics" {
+		respond(conn, "404 Not Found", "not found\n")
+		return
+	}
+
+	ex.scrape(conn)
+}
+
+// each loop reads its gauges in its own goroutine and posts them back, the
+// response is sent when all loops report or collectTimeout elapses
+func (ex *exporter) scrape(conn goreactor.TCPConnection) {
+	if ex.collecting != nil {
+		ex.collecting.conns = append(ex.collecting.conns, conn)
+		return
+	}
+
+	// the base loop is included, the acceptor lives in it
+	baseLoop, others := ex.server.GetAllLoops()
+	loops := append([]eventloop.EventLoop{baseLoop}, others...)
+
+	c := &collection{
+		conns:     []goreactor.TCPConnection{conn},
+		gauges:    make([]*loopGauges, len(loops)),
+		remaining: len(loops),
+	}
+	ex.collecting = c
+	c.timerID = ex.loop.RunAt(time.Now().Add(collectTimeout), 0, func(int) {
+		c.timerID = 0
+		ex.finish(c)
+	})
+
+	for i, l := range loops {
+		i, l := i, l
+		if ex.probing[l.GetID()] {
+			c.remaining--
+			continue
+		}
+		ex.probing[l.GetID()] = true
+
+		// runs in place if l is the loop of the exporter
+		l.RunInLoop(func() {
+			g := &loopGauges{
+				id:       l.GetID(),
+				channels: l.GetChannelCount(),
+				functors: l.GetFunctorCount(),
+				timers:   l.GetTimerCount(),
+			}
+			ex.loop.RunInLoop(func() {
+				delete(ex.probing, g.id)
+				if ex.collecting != c {
+					return
+				}
+				c.gauges[i] = g
+				c.remaining--
+				if c.remaining == 0 {
+					ex.finish(c)
+				}
+			})
+		})
+	}
+	if c.remaining == 0 {
+		ex.finish(c)
+	}
+}
+
+func (ex *exporter) finish(c *collection) {
+	if ex.collecting != c {
+		return
+	}
+	ex.collecting = nil
+	if c.timerID != 0 {
+		ex.loop.CancelTimer(c.timerID)
+		c.timerID = 0
+	}
+
+	body := ex.collect(c.gauges)
+	for _, conn := range c.conns {
+		respond(conn, "200 OK", body)
+	}
+}
+
+// Send and ShutdownWrite can be called in any goroutine
+func respond(conn goreactor.TCPConnection, status string, body string) {
+	header := "HTTP/1.1 " + status + "\r\n" +
+		"Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"Connection: close\r\n\r\n"
+	conn.SendV([][]byte{[]byte(header), []byte(body)})
+	conn.ShutdownWrite()
+}
+
+// the text of all metrics, loops without gauges are left out of the loop gauges,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/
+func (ex *exporter) collect(gauges []*loopGauges) string {
+	w := &writer{}
+	stats := ex.server.Stats()
+
+	w.metric("goreactor_connections_accepted_total", "counter",
+		"Connections accepted by the server.")
+	w.sample("goreactor_connections_accepted_total", "", stats.Accepted)
+
+	w.metric("goreactor_connections_rejected_total", "counter",
+		"Connections closed right after accepted by the connection rate limit.")
+	w.sample("goreactor_connections_rejected_total", "", stats.Rejected)
+
+	w.metric("goreactor_connections_active", "gauge", "Connections alive.")
+	w.sample("goreactor_connections_active", "", stats.Active)
+
+	w.metric("goreactor_connections_closed_total", "counter",
+		"Connections closed, by disconnect reason.")
+	reasons := make([]goreactor.DisconnectReason, 0, len(stats.Closed))
+	for r := range stats.Closed {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	for _, r := range reasons {
+		w.sample("goreactor_connections_closed_total", `reason="`+r.String()+`"`, stats.Closed[r])
+	}
+
+	loopCounters := []struct {
+		name  string
+		typ   string
+		help  string
+		value func(goreactor.LoopStats) interface{}
+	}{
+		{"goreactor_loop_connections_active", "gauge", "Connections alive in the loop.",
+			func(ls goreactor.LoopStats) interface{} { return ls.Active }},
+		{"goreactor_loop_read_bytes_total", "counter", "Bytes read by connections of the loop.",
+			func(ls goreactor.LoopStats) interface{} { return ls.BytesRead }},
+		{"goreactor_loop_written_bytes_total", "counter", "Bytes written by connections of the loop.",
+			func(ls goreactor.LoopStats) interface{} { return ls.BytesWritten }},
+		{"goreactor_loop_messages_total", "counter", "Calls of the message callback in the loop.",
+			func(ls goreactor.LoopStats) interface{} { return ls.Messages }},
+		{"goreactor_loop_write_blocked_total", "counter",
+			"Sends which could not be written directly because the kernel buffer was full.",
+			func(ls goreactor.LoopStats) interface{} { return ls.WriteBlocked }},
+	}
+	for _, c := range loopCounters {
+		w.metric(c.name, c.typ, c.help)
+		for _, ls := range stats.Loops {
+			w.sample(c.name, loopLabel(ls.LoopID), c.value(ls))
+		}
+	}
+
+	gaugeValues := []struct {
+		name  string
+		help  string
+		value func(*loopGauges) int
+	}{
+		{"goreactor_loop_channels", "Channels registered in the loop.",
+			func(g *loopGauges) int { return g.channels }},
+		{"goreactor_loop_pending_functors", "Functors queued but not executed yet.",
+			func(g *loopGauges) int { return g.functors }},
+		{"goreactor_loop_timers", "Timers waiting to be triggered.",
+			func(g *loopGauges) int { return g.timers }},
+	}
+	for _, gv := range gaugeValues {
+		w.metric(gv.name, "gauge", gv.help)
+		for _, g := range gauges {
+			if g != nil {
+				w.sample(gv.name, loopLabel(g.id), gv.value(g))
+			}
+		}
+	}
+
+	if ex.logger != nil {
+		backup, full := ex.logger.Metrics()
+		w.metric("goreactor_logger_backup_buffers", "gauge", "Empty buffers of the logger.")
+		w.sample("goreactor_logger_backup_buffers", "", backup)
+		w.metric("goreactor_logger_full_buffers", "gauge", "Buffers waiting to be written to the file.")
+		w.sample("goreactor_logger_full_buffers", "", full)
+	}
+
+	return w.String()
+}
+
+func loopLabel(id int) string {
+	return `loop="` + strconv.Itoa(id) + `"`
+}
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) metric(name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labels is empty or formatted like `loop="1"`
+func (w *writer) sample(name string, labels string, value interface{}) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %v\n", name, labels, value)
+	} else {
+		fmt.Fprintf(w, "%s %v\n", name, value)
+	}
+}
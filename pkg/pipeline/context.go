@@ -0,0 +1,66 @@
+package pipeline
+
+import goreactor "github.com/markity/go-reactor"
+
+// Context binds a handler to a pipeline, each handler added to a pipeline has
+// its own Context
+type Context struct {
+	name     string
+	handler  interface{}
+	pipeline *pipeline
+
+	prev *Context
+	next *Context
+
+	// set by Remove
+	removed bool
+
+	// per-connection state of the handler
+	value interface{}
+}
+
+func (ctx *Context) Name() string {
+	return ctx.name
+}
+
+func (ctx *Context) Handler() interface{} {
+	return ctx.handler
+}
+
+func (ctx *Context) Pipeline() Pipeline {
+	return ctx.pipeline
+}
+
+func (ctx *Context) Conn() goreactor.TCPConnection {
+	return ctx.pipeline.conn
+}
+
+// state of the handler for this connection, a handler instance can be shared
+// by pipelines and keep its state here
+func (ctx *Context) SetValue(v interface{}) {
+	ctx.value = v
+}
+
+func (ctx *Context) Value() interface{} {
+	return ctx.value
+}
+
+// true after the handler is removed from the pipeline
+func (ctx *Context) IsRemoved() bool {
+	return ctx.removed
+}
+
+// pass msg to the next inbound handler
+func (ctx *Context) FireRead(msg interface{}) {
+	ctx.pipeline.fireRead(ctx.next, msg)
+}
+
+// pass msg to the previous outbound handler, or send it if there is none
+func (ctx *Context) Write(msg interface{}) {
+	ctx.pipeline.write(ctx.prev, msg)
+}
+
+// pass the inactive event to the next InactiveHandler
+func (ctx *Context) FireInactive() {
+	ctx.pipeline.fireInactive(ctx.next)
+}
@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+
+	asynclog "github.com/markity/go-reactor/pkg/async_log"
+	"github.com/markity/go-reactor/pkg/buffer"
+	"github.com/markity/go-reactor/pkg/codec"
+)
+
+// frameCodecHandler decodes the input buffer into []byte frames, and encodes
+// outbound []byte frames
+type frameCodecHandler struct {
+	dec          codec.Decoder
+	enc          codec.Encoder
+	maxFrameSize int
+}
+
+// inbound buffer.Buffer is decoded by dec into []byte frames, outbound []byte is
+// encoded by enc into [][]byte. enc can be nil if outbound messages are encoded
+// by the user. the connection is force closed if dec or enc fails. the handler
+// has no state, it can be shared by pipelines
+func NewFrameCodecHandler(dec codec.Decoder, enc codec.Encoder, maxFrameSize int) interface{} {
+	if dec == nil {
+		panic("nil decoder")
+	}
+	if maxFrameSize < 0 {
+		panic(maxFrameSize)
+	}
+	return &frameCodecHandler{dec: dec, enc: enc, maxFrameSize: maxFrameSize}
+}
+
+func (h *frameCodecHandler) HandleRead(ctx *Context, msg interface{}) {
+	buf, ok := msg.(buffer.Buffer)
+	if !ok {
+		ctx.FireRead(msg)
+		return
+	}
+
+	// the handler may be removed by the next handlers, for example after an
+	// upgrade, the rest bytes are passed on as they are
+	for !ctx.IsRemoved() {
+		frame, err := h.dec.Decode(buf, h.maxFrameSize)
+		if err != nil {
+			ctx.Conn().ForceClose()
+			return
+		}
+		if frame == nil {
+			return
+		}
+		ctx.FireRead(frame)
+		if !ctx.Conn().IsConnected() {
+			return
+		}
+	}
+	if buf.ReadableBytes() != 0 {
+		ctx.FireRead(buf)
+	}
+}
+
+func (h *frameCodecHandler) HandleWrite(ctx *Context, msg interface{}) {
+	frame, ok := msg.([]byte)
+	if !ok || h.enc == nil {
+		ctx.Write(msg)
+		return
+	}
+
+	bss, err := h.enc.Encode(frame)
+	if err != nil {
+		ctx.Conn().ForceClose()
+		return
+	}
+	ctx.Write(bss)
+}
+
+// loggingHandler logs inbound and outbound messages and the close of the
+// connection, messages are passed on unchanged
+type loggingHandler struct {
+	logger asynclog.Logger
+	level  asynclog.LoggerLevel
+}
+
+func NewLoggingHandler(logger asynclog.Logger, level asynclog.LoggerLevel) interface{} {
+	return &loggingHandler{logger: logger, level: level}
+}
+
+func (h *loggingHandler) HandleRead(ctx *Context, msg interface{}) {
+	h.logger.Logf(h.level, "%v %s read %s", ctx.Conn().GetRemoteAddrPort(), ctx.Name(), describe(msg))
+	ctx.FireRead(msg)
+}
+
+func (h *loggingHandler) HandleWrite(ctx *Context, msg interface{}) {
+	h.logger.Logf(h.level, "%v %s write %s", ctx.Conn().GetRemoteAddrPort(), ctx.Name(), describe(msg))
+	ctx.Write(msg)
+}
+
+func (h *loggingHandler) HandleInactive(ctx *Context) {
+	h.logger.Logf(h.level, "%v %s closed %v %v", ctx.Conn().GetRemoteAddrPort(), ctx.Name(),
+		ctx.Conn().GetDisconnectReason(), ctx.Conn().GetLastError())
+	ctx.FireInactive()
+}
+
+func describe(msg interface{}) string {
+	switch m := msg.(type) {
+	case buffer.Buffer:
+		return "buffer of " + strconv.Itoa(m.ReadableBytes()) + " bytes"
+	case []byte:
+		return strconv.Itoa(len(m)) + " bytes"
+	case [][]byte:
+		n := 0
+		for _, bs := range m {
+			n += len(bs)
+		}
+		return strconv.Itoa(n) + " bytes in " + strconv.Itoa(len(m)) + " slices"
+	default:
+		return fmt.Sprintf("%T", msg)
+	}
+}
@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"fmt"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// connection context key of the pipeline
+const contextKey = "__pipeline"
+
+// called for inbound messages, the first inbound handler receives the input
+// buffer.Buffer of the connection. pass a message to the next inbound handler
+// with ctx.FireRead, or drop it
+type InboundHandler interface {
+	HandleRead(ctx *Context, msg interface{})
+}
+
+// called for outbound messages from the last handler to the first one, pass a
+// message to the previous outbound handler with ctx.Write. the messages reaching
+// the head are sent, they must be []byte or [][]byte, both are copied like
+// TCPConnection.Send, so the caller can reuse them after Write
+type OutboundHandler interface {
+	HandleWrite(ctx *Context, msg interface{})
+}
+
+// called after the connection is closed, pass it on with ctx.FireInactive
+type InactiveHandler interface {
+	HandleInactive(ctx *Context)
+}
+
+// called when the handler is added to or removed from a pipeline, it can be used
+// to setup or release per-connection state
+type LifecycleHandler interface {
+	HandleAdded(ctx *Context)
+	HandleRemoved(ctx *Context)
+}
+
+// Pipeline is an ordered list of handlers of a connection, inbound messages flow
+// from the first handler to the last one, outbound messages flow backwards.
+// handlers can be added and removed at any time, but all methods must be called
+// in the loop goroutine of the connection, for example in handlers or callbacks
+type Pipeline interface {
+	// handlers must implement at least one of InboundHandler, OutboundHandler,
+	// InactiveHandler and LifecycleHandler. names must be unique, it panics if
+	// the name exists or base does not exist
+	AddFirst(name string, handler interface{}) Pipeline
+	AddLast(name string, handler interface{}) Pipeline
+	AddBefore(base string, name string, handler interface{}) Pipeline
+	AddAfter(base string, name string, handler interface{}) Pipeline
+
+	// returns the removed handler, nil if the name does not exist. messages which
+	// are being handled by it are still passed to its neighbours
+	Remove(name string) interface{}
+
+	// nil if the name does not exist
+	Get(name string) interface{}
+	Names() []string
+
+	// pass msg to the first inbound handler
+	FireRead(msg interface{})
+
+	// pass msg to the last outbound handler
+	Write(msg interface{})
+
+	Conn() goreactor.TCPConnection
+}
+
+type pipeline struct {
+	conn goreactor.TCPConnection
+
+	// a doubly linked list
+	head *Context
+	tail *Context
+}
+
+// create a pipeline for conn, it is usually called in the connected callback.
+// the disconnected callback of conn is set to notify InactiveHandlers
+func New(conn goreactor.TCPConnection) Pipeline {
+	p := &pipeline{conn: conn}
+	conn.SetContext(contextKey, p)
+	conn.SetDisConnectedCallback(func(goreactor.TCPConnection) {
+		p.fireInactive(p.head)
+	})
+	return p
+}
+
+// returns the pipeline created by New, nil if there is no pipeline
+func Get(conn goreactor.TCPConnection) Pipeline {
+	p, ok := conn.GetContext(contextKey)
+	if !ok {
+		return nil
+	}
+	return p.(*pipeline)
+}
+
+// a message callback which passes the input buffer to the pipeline of the
+// connection, see TCPServer.SetMessageCallback. the pipeline must be created in
+// the connected callback
+func MessageCallback(conn goreactor.TCPConnection, buf buffer.Buffer) {
+	p, ok := conn.GetContext(contextKey)
+	if !ok {
+		panic("pipeline is not created")
+	}
+	p.(*pipeline).FireRead(buf)
+}
+
+func (p *pipeline) AddFirst(name string, handler interface{}) Pipeline {
+	ctx := p.newContext(name, handler)
+	ctx.next = p.head
+	p.link(ctx)
+	return p
+}
+
+func (p *pipeline) AddLast(name string, handler interface{}) Pipeline {
+	ctx := p.newContext(name, handler)
+	ctx.prev = p.tail
+	p.link(ctx)
+	return p
+}
+
+func (p *pipeline) AddBefore(base string, name string, handler interface{}) Pipeline {
+	b := p.mustFind(base)
+	ctx := p.newContext(name, handler)
+	ctx.prev, ctx.next = b.prev, b
+	p.link(ctx)
+	return p
+}
+
+func (p *pipeline) AddAfter(base string, name string, handler interface{}) Pipeline {
+	b := p.mustFind(base)
+	ctx := p.newContext(name, handler)
+	ctx.prev, ctx.next = b, b.next
+	p.link(ctx)
+	return p
+}
+
+func (p *pipeline) Remove(name string) interface{} {
+	ctx := p.find(name)
+	if ctx == nil {
+		return nil
+	}
+
+	if ctx.prev != nil {
+		ctx.prev.next = ctx.next
+	} else {
+		p.head = ctx.next
+	}
+	if ctx.next != nil {
+		ctx.next.prev = ctx.prev
+	} else {
+		p.tail = ctx.prev
+	}
+
+	// prev and next are kept, see Context.FireRead
+	ctx.removed = true
+	if h, ok := ctx.handler.(LifecycleHandler); ok {
+		h.HandleRemoved(ctx)
+	}
+	return ctx.handler
+}
+
+func (p *pipeline) Get(name string) interface{} {
+	ctx := p.find(name)
+	if ctx == nil {
+		return nil
+	}
+	return ctx.handler
+}
+
+func (p *pipeline) Names() []string {
+	var names []string
+	for ctx := p.head; ctx != nil; ctx = ctx.next {
+		names = append(names, ctx.name)
+	}
+	return names
+}
+
+func (p *pipeline) FireRead(msg interface{}) {
+	p.fireRead(p.head, msg)
+}
+
+func (p *pipeline) Write(msg interface{}) {
+	p.write(p.tail, msg)
+}
+
+func (p *pipeline) Conn() goreactor.TCPConnection {
+	return p.conn
+}
+
+func (p *pipeline) newContext(name string, handler interface{}) *Context {
+	switch handler.(type) {
+	case InboundHandler, OutboundHandler, InactiveHandler, LifecycleHandler:
+	default:
+		panic(fmt.Sprintf("%T is not a handler", handler))
+	}
+	if p.find(name) != nil {
+		panic("duplicated handler name " + name)
+	}
+	return &Context{name: name, handler: handler, pipeline: p}
+}
+
+// prev and next of ctx are set
+func (p *pipeline) link(ctx *Context) {
+	if ctx.prev != nil {
+		ctx.prev.next = ctx
+	} else {
+		p.head = ctx
+	}
+	if ctx.next != nil {
+		ctx.next.prev = ctx
+	} else {
+		p.tail = ctx
+	}
+
+	if h, ok := ctx.handler.(LifecycleHandler); ok {
+		h.HandleAdded(ctx)
+	}
+}
+
+func (p *pipeline) find(name string) *Context {
+	for ctx := p.head; ctx != nil; ctx = ctx.next {
+		if ctx.name == name {
+			return ctx
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) mustFind(name string) *Context {
+	ctx := p.find(name)
+	if ctx == nil {
+		panic("handler not found " + name)
+	}
+	return ctx
+}
+
+// pass msg to the first inbound handler from ctx, ctx can be nil
+func (p *pipeline) fireRead(ctx *Context, msg interface{}) {
+	for ; ctx != nil; ctx = ctx.next {
+		if h, ok := ctx.handler.(InboundHandler); ok {
+			h.HandleRead(ctx, msg)
+			return
+		}
+	}
+
+	// nobody consumes the input buffer, drop the bytes or it grows forever
+	if buf, ok := msg.(buffer.Buffer); ok {
+		buf.RetrieveAll()
+	}
+}
+
+// pass msg to the first outbound handler from ctx backwards, ctx can be nil
+func (p *pipeline) write(ctx *Context, msg interface{}) {
+	for ; ctx != nil; ctx = ctx.prev {
+		if h, ok := ctx.handler.(OutboundHandler); ok {
+			h.HandleWrite(ctx, msg)
+			return
+		}
+	}
+
+	switch m := msg.(type) {
+	case []byte:
+		p.conn.Send(m)
+	case [][]byte:
+		// the slices may be frames of the caller, see codec.Encoder
+		sz := 0
+		for _, bs := range m {
+			sz += len(bs)
+		}
+		joined := make([]byte, 0, sz)
+		for _, bs := range m {
+			joined = append(joined, bs...)
+		}
+		p.conn.SendOwned(joined)
+	default:
+		panic(fmt.Sprintf("unsupported outbound message %T", msg))
+	}
+}
+
+func (p *pipeline) fireInactive(ctx *Context) {
+	for ; ctx != nil; ctx = ctx.next {
+		if h, ok := ctx.handler.(InactiveHandler); ok {
+			h.HandleInactive(ctx)
+			return
+		}
+	}
+}
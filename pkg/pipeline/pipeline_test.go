@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/codec"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+type readFunc func(ctx *Context, msg interface{})
+
+func (f readFunc) HandleRead(ctx *Context, msg interface{}) {
+	f(ctx, msg)
+}
+
+// the frame is queued since it is larger than the kernel buffer, it is reused
+// by the handler right after Write
+func TestWriteCopiesFrames(t *testing.T) {
+	payload := make([]byte, 8<<20)
+	rand.Read(payload)
+	for i := range payload {
+		if payload[i] == '\n' {
+			payload[i] = 0
+		}
+	}
+
+	loop := eventloop.NewEventLoop()
+	server := goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin())
+	server.SetConnectionCallback(func(c goreactor.TCPConnection) {
+		New(c).
+			AddLast("codec", NewFrameCodecHandler(codec.NewLineDecoder(), codec.NewLineEncoder(), 0)).
+			AddLast("reply", readFunc(func(ctx *Context, msg interface{}) {
+				frame := bytes.Clone(payload)
+				ctx.Write(frame)
+				for i := range frame {
+					frame[i] = 'x'
+				}
+			}))
+	})
+	server.SetMessageCallback(MessageCallback)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	conn, err := net.Dial("tcp", server.GetListenAddrPort().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("go\n"))
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReaderSize(conn, 64<<10).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(line, append(payload, "\r\n"...)) {
+		t.Fatal("the frame is modified after Write")
+	}
+}
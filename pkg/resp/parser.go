@@ -0,0 +1,219 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+var ErrProtocol = errors.New("protocol error")
+
+const (
+	// lines like simple strings and lengths can not be longer than it
+	maxLineSize = 64 * 1024
+
+	// aggregates can not be nested deeper than it
+	maxDepth = 32
+
+	// aggregates can not have more elements than it
+	maxElements = 1024 * 1024
+)
+
+// reads a value out of buf, ok is false if more bytes are needed. bulk strings
+// larger than maxBulkSize are errors, see ErrProtocol. the value does not
+// reference buf
+func ReadValue(buf buffer.Buffer, maxBulkSize int) (v Value, ok bool, err error) {
+	v, n, err := Parse(buf.Peek(), maxBulkSize)
+	if err != nil || n == 0 {
+		return Value{}, false, err
+	}
+
+	buf.Retrieve(n)
+	return v, true, nil
+}
+
+// parses a value at the beginning of data, n is the size of it, or 0 if more
+// bytes are needed
+func Parse(data []byte, maxBulkSize int) (v Value, n int, err error) {
+	var s scanner
+	n, err = s.scan(data, maxBulkSize)
+	if err != nil || n == 0 {
+		return Value{}, 0, err
+	}
+	return parse(data[:n], maxBulkSize, 0)
+}
+
+// scanner finds the end of a value received in pieces. elements are checked once
+// they are complete and are not checked again when more bytes arrive, so a large
+// aggregate is not parsed again for each read
+type scanner struct {
+	// bytes of complete elements and headers of aggregates scanned
+	offset int
+
+	// elements left in open aggregates, the innermost one is the last
+	remaining []int
+}
+
+// the size of the value at the beginning of data, or 0 if more bytes are needed.
+// data must begin with the bytes given to the last call until the size is
+// returned or an error occurs
+func (s *scanner) scan(data []byte, maxBulkSize int) (int, error) {
+	for {
+		_, n, count, err := parseElement(data[s.offset:], maxBulkSize, false)
+		if err == nil && count >= 0 && len(s.remaining) >= maxDepth {
+			err = fmt.Errorf("%w: too deeply nested", ErrProtocol)
+		}
+		if err != nil {
+			s.offset = 0
+			s.remaining = s.remaining[:0]
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		s.offset += n
+
+		if count > 0 {
+			s.remaining = append(s.remaining, count)
+			continue
+		}
+		// the element is complete, so are aggregates it completes
+		for len(s.remaining) != 0 {
+			s.remaining[len(s.remaining)-1]--
+			if s.remaining[len(s.remaining)-1] != 0 {
+				break
+			}
+			s.remaining = s.remaining[:len(s.remaining)-1]
+		}
+		if len(s.remaining) == 0 {
+			n, s.offset = s.offset, 0
+			return n, nil
+		}
+	}
+}
+
+// parses a value which is complete, see scanner
+func parse(data []byte, maxBulkSize int, depth int) (Value, int, error) {
+	v, n, count, err := parseElement(data, maxBulkSize, true)
+	if err != nil || n == 0 || count < 0 {
+		return v, n, err
+	}
+	if depth >= maxDepth {
+		return Value{}, 0, fmt.Errorf("%w: too deeply nested", ErrProtocol)
+	}
+
+	// the count is not trusted before the elements arrive
+	v.Elems = make([]Value, 0, min(count, 1024))
+	for i := 0; i < count; i++ {
+		e, en, err := parse(data[n:], maxBulkSize, depth+1)
+		if err != nil || en == 0 {
+			return Value{}, 0, err
+		}
+		v.Elems = append(v.Elems, e)
+		n += en
+	}
+	return v, n, nil
+}
+
+// parses an element at the beginning of data, n is 0 if more bytes are needed.
+// count is the number of elements following the header of an aggregate, they are
+// not parsed, and it is -1 for other types. strings are copied into v only if
+// build is true
+func parseElement(data []byte, maxBulkSize int, build bool) (v Value, n int, count int, err error) {
+	if len(data) == 0 {
+		return Value{}, 0, -1, nil
+	}
+
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) > maxLineSize {
+			return Value{}, 0, -1, fmt.Errorf("%w: line is too long", ErrProtocol)
+		}
+		return Value{}, 0, -1, nil
+	}
+	line := data[1:end]
+	n = end + 2
+
+	v = Value{Type: Type(data[0])}
+	switch v.Type {
+	case TypeSimpleString, TypeError:
+		if build {
+			v.Str = append([]byte{}, line...)
+		}
+	case TypeInteger:
+		i, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid integer", ErrProtocol)
+		}
+		v.Int = i
+	case TypeNull:
+		if len(line) != 0 {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid null", ErrProtocol)
+		}
+	case TypeBoolean:
+		switch string(line) {
+		case "t":
+			v.Bool = true
+		case "f":
+		default:
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid boolean", ErrProtocol)
+		}
+	case TypeDouble:
+		f, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid double", ErrProtocol)
+		}
+		v.Float = f
+	case TypeBigNumber:
+		s := line
+		if len(s) != 0 && (s[0] == '-' || s[0] == '+') {
+			s = s[1:]
+		}
+		if len(s) == 0 || len(bytes.Trim(s, "0123456789")) != 0 {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid big number", ErrProtocol)
+		}
+		if build {
+			v.Str = append([]byte{}, line...)
+		}
+	case TypeBulkString, TypeBulkError, TypeVerbatimString:
+		size, err := strconv.Atoi(string(line))
+		if v.Type == TypeBulkString && err == nil && size == -1 {
+			return Value{Type: TypeNull}, n, -1, nil
+		}
+		if err != nil || size < 0 || size > maxBulkSize {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		if len(data) < n+size+2 {
+			return Value{}, 0, -1, nil
+		}
+		if data[n+size] != '\r' || data[n+size+1] != '\n' {
+			return Value{}, 0, -1, fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+		}
+		if v.Type == TypeVerbatimString && (size < 4 || data[n+3] != ':') {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid verbatim string", ErrProtocol)
+		}
+		if build {
+			v.Str = append([]byte{}, data[n:n+size]...)
+		}
+		n += size + 2
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		count, err := strconv.Atoi(string(line))
+		if v.Type == TypeArray && err == nil && count == -1 {
+			return Value{Type: TypeNull}, n, -1, nil
+		}
+		if err != nil || count < 0 || count > maxElements {
+			return Value{}, 0, -1, fmt.Errorf("%w: invalid aggregate length", ErrProtocol)
+		}
+		if v.Type == TypeMap {
+			count *= 2
+		}
+		return v, n, count, nil
+	default:
+		return Value{}, 0, -1, fmt.Errorf("%w: invalid type byte %q", ErrProtocol, data[0])
+	}
+
+	return v, n, -1, nil
+}
@@ -0,0 +1,323 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// values of every type, aggregates are nested
+func sampleValues() []Value {
+	return []Value{
+		SimpleString("OK"),
+		Error("ERR something is wrong"),
+		Integer(0),
+		Integer(math.MinInt64),
+		BulkString([]byte{}),
+		BulkString([]byte("binary\r\n\x00data")),
+		Array(),
+		Array(Integer(1), BulkString([]byte("two")), Array(Null(), Boolean(true))),
+		Null(),
+		Boolean(true),
+		Boolean(false),
+		Double(1.5),
+		Double(-0.25),
+		Double(math.Inf(1)),
+		Double(math.Inf(-1)),
+		{Type: TypeBigNumber, Str: []byte("-3492890328409238509324850943850943825024385")},
+		{Type: TypeBulkError, Str: []byte("SYNTAX invalid\r\nsyntax")},
+		{Type: TypeVerbatimString, Str: []byte("txt:Some string")},
+		Map(SimpleString("first"), Integer(1), BulkString([]byte("second")), Map(Null(), Double(2))),
+		{Type: TypeSet, Elems: []Value{Integer(1), SimpleString("x")}},
+		{Type: TypePush, Elems: []Value{BulkString([]byte("message")), BulkString([]byte("channel"))}},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, resp3 := range []bool{true, false} {
+		for _, v := range sampleValues() {
+			data := v.AppendTo(nil, resp3)
+			got, n, err := Parse(append(data, "+next\r\n"...), math.MaxInt32)
+			if err != nil || n != len(data) {
+				t.Fatalf("%q: n %d, %v", data, n, err)
+			}
+			if resp3 && got.Type != v.Type {
+				t.Fatalf("%q: type %q, want %q", data, got.Type, v.Type)
+			}
+			// bulk errors become simple errors, line breaks are replaced
+			if v.Type == TypeBulkError && !resp3 {
+				continue
+			}
+			if again := got.AppendTo(nil, resp3); !bytes.Equal(again, data) {
+				t.Fatalf("%q is serialized as %q after parsing", data, again)
+			}
+		}
+	}
+
+	v, _, _ := Parse([]byte(",nan\r\n"), 0)
+	if !math.IsNaN(v.Float) {
+		t.Fatal("nan is not parsed")
+	}
+}
+
+func TestNulls(t *testing.T) {
+	for _, s := range []string{"_\r\n", "$-1\r\n", "*-1\r\n"} {
+		v, n, err := Parse([]byte(s), 0)
+		if err != nil || n != len(s) || v.Type != TypeNull {
+			t.Fatalf("%q: %v %d %v", s, v, n, err)
+		}
+	}
+
+	if s := string(Null().AppendTo(nil, true)); s != "_\r\n" {
+		t.Fatalf("RESP3 null is %q", s)
+	}
+	if s := string(Null().AppendTo(nil, false)); s != "$-1\r\n" {
+		t.Fatalf("RESP2 null is %q", s)
+	}
+}
+
+func TestDowngradeToRESP2(t *testing.T) {
+	tests := []struct {
+		v    Value
+		want string
+	}{
+		{Boolean(true), ":1\r\n"},
+		{Boolean(false), ":0\r\n"},
+		{Double(1.5), "$3\r\n1.5\r\n"},
+		{Double(math.Inf(-1)), "$4\r\n-inf\r\n"},
+		{Value{Type: TypeBigNumber, Str: []byte("12345678901234567890")}, "$20\r\n12345678901234567890\r\n"},
+		{Value{Type: TypeBulkError, Str: []byte("ERR bad")}, "-ERR bad\r\n"},
+		{Value{Type: TypeBulkError, Str: []byte("ERR two\r\nlines")}, "-ERR two  lines\r\n"},
+		{Value{Type: TypeVerbatimString, Str: []byte("txt:hi")}, "$2\r\nhi\r\n"},
+		{Map(BulkString([]byte("k")), Null()), "*2\r\n$1\r\nk\r\n$-1\r\n"},
+		{Value{Type: TypeSet, Elems: []Value{Boolean(true)}}, "*1\r\n:1\r\n"},
+		{Value{Type: TypePush, Elems: []Value{Double(2)}}, "*1\r\n$1\r\n2\r\n"},
+		{Array(Map(Integer(1), Boolean(false))), "*1\r\n*2\r\n:1\r\n:0\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.v.AppendTo(nil, false)); got != tt.want {
+			t.Fatalf("%v is written as %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestParseInPieces(t *testing.T) {
+	var elems []Value
+	for i := 0; i < 100; i++ {
+		elems = append(elems, sampleValues()...)
+	}
+	data := Array(elems...).AppendTo(nil, true)
+
+	for _, step := range []int{1, 7, 4096} {
+		var s scanner
+		for i := step; ; i += step {
+			i = min(i, len(data))
+			n, err := s.scan(data[:i], math.MaxInt32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i < len(data) && n != 0 {
+				t.Fatalf("step %d: complete after %d of %d bytes", step, i, len(data))
+			}
+			if i == len(data) {
+				if n != len(data) {
+					t.Fatalf("step %d: n %d, want %d", step, n, len(data))
+				}
+				break
+			}
+		}
+	}
+
+	buf := buffer.NewBuffer()
+	for i := 0; i < len(data); i += 1000 {
+		buf.Append(data[i:min(i+1000, len(data))])
+		v, ok, err := ReadValue(buf, math.MaxInt32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (i+1000 >= len(data)) {
+			t.Fatalf("ok is %v after %d bytes", ok, i+1000)
+		}
+		if ok && (len(v.Elems) != len(elems) || buf.ReadableBytes() != 0) {
+			t.Fatal("the value is not read")
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"?what\r\n",
+		":12a\r\n",
+		"#x\r\n",
+		",one\r\n",
+		"(12x\r\n",
+		"_0\r\n",
+		"$-2\r\n",
+		"$10\r\n",
+		"$3\r\nabcd\r\n",
+		"=2\r\nhi\r\n",
+		"%-1\r\n",
+		"*2\r\n:1\r\n?\r\n",
+		strings.Repeat("*1\r\n", maxDepth+1),
+		"+" + strings.Repeat("x", maxLineSize+1),
+	}
+	for _, s := range tests {
+		_, _, err := Parse([]byte(s), 8)
+		if !errors.Is(err, ErrProtocol) {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+
+	// the depth limit is also kept when the value is received in pieces
+	var s scanner
+	deep := []byte(strings.Repeat("*1\r\n", maxDepth+1))
+	for i := 1; i <= len(deep); i++ {
+		if _, err := s.scan(deep[:i], 0); err != nil {
+			return
+		}
+	}
+	t.Fatal("too deep nesting is accepted")
+}
+
+func startServer(t *testing.T, setup func(Server)) string {
+	loop := eventloop.NewEventLoop()
+	server := NewServer(goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin()))
+	if setup != nil {
+		setup(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	return server.TCPServer().GetListenAddrPort().String()
+}
+
+func command(args ...string) []byte {
+	elems := make([]Value, len(args))
+	for i, a := range args {
+		elems[i] = BulkString([]byte(a))
+	}
+	return Array(elems...).AppendTo(nil, false)
+}
+
+// reads values until the server closes the connection
+func readAll(t *testing.T, conn net.Conn) []Value {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []Value
+	for len(data) != 0 {
+		v, n, err := Parse(data, math.MaxInt32)
+		if err != nil || n == 0 {
+			t.Fatalf("%q: %v", data, err)
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values
+}
+
+// replies are in the order of commands, though they are replied out of order
+func TestPipelining(t *testing.T) {
+	addr := startServer(t, func(s Server) {
+		s.HandleFunc("delay", func(w ReplyWriter, cmd *Command) {
+			ms, _ := ParseInt(cmd.Args[0])
+			go func() {
+				time.Sleep(time.Duration(ms) * time.Millisecond)
+				w.Reply(Integer(ms))
+			}()
+		})
+		s.HandleFunc("true", func(w ReplyWriter, cmd *Command) {
+			w.Reply(Boolean(true))
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var req []byte
+	for i := 0; i < 50; i++ {
+		req = append(req, command("DELAY", strconv.Itoa((50-i)%7))...)
+	}
+	req = append(req, command("TRUE")...)
+	req = append(req, "PING inline\r\n"...)
+	req = append(req, command("HELLO", "3")...)
+	req = append(req, command("TRUE")...)
+	req = append(req, command("nope")...)
+	// a large argument is received in many reads
+	big := strings.Repeat("x", 4<<20)
+	req = append(req, command("ECHO", big)...)
+	req = append(req, command("QUIT")...)
+	req = append(req, command("PING")...)
+
+	go conn.Write(req)
+	values := readAll(t, conn)
+
+	if len(values) != 57 {
+		t.Fatalf("%d replies", len(values))
+	}
+	for i := 0; i < 50; i++ {
+		if values[i].Type != TypeInteger || values[i].Int != int64((50-i)%7) {
+			t.Fatalf("reply %d is %v", i, values[i])
+		}
+	}
+	rest := values[50:]
+	if rest[0].Type != TypeInteger || rest[0].Int != 1 {
+		t.Fatalf("RESP2 boolean is %v", rest[0])
+	}
+	if rest[1].Type != TypeBulkString || string(rest[1].Str) != "inline" {
+		t.Fatalf("inline PING got %v", rest[1])
+	}
+	if rest[2].Type != TypeMap {
+		t.Fatalf("HELLO 3 got %v", rest[2])
+	}
+	if rest[3].Type != TypeBoolean || !rest[3].Bool {
+		t.Fatalf("RESP3 boolean is %v", rest[3])
+	}
+	if rest[4].Type != TypeError || !strings.HasPrefix(string(rest[4].Str), "ERR unknown command") {
+		t.Fatalf("unknown command got %v", rest[4])
+	}
+	if string(rest[5].Str) != big {
+		t.Fatal("ECHO got a different string")
+	}
+	// no reply after QUIT
+	if rest[6].Type != TypeSimpleString || string(rest[6].Str) != "OK" {
+		t.Fatalf("QUIT got %v", rest[6])
+	}
+}
+
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	addr := startServer(t, nil)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := append(command("PING"), "*1\r\n:1\r\n"...)
+	req = append(req, command("PING")...)
+	conn.Write(req)
+
+	values := readAll(t, conn)
+	if len(values) != 2 || values[0].Type != TypeSimpleString || values[1].Type != TypeError {
+		t.Fatalf("got %v", values)
+	}
+}
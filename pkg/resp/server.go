@@ -0,0 +1,394 @@
+package resp
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// connection context key of the connection state
+const contextKey = "__resp"
+
+// reading stops when so many commands are waiting for replies
+const maxPipelined = 1024
+
+type Command struct {
+	// upper case
+	Name string
+
+	// arguments after the name, they are not referenced by the server
+	Args [][]byte
+
+	Conn goreactor.TCPConnection
+}
+
+type ReplyWriter interface {
+	// reply the command, it must be called exactly once for each command, in the
+	// handler or later in any goroutine. replies of pipelined commands are sent in
+	// the order of the commands
+	Reply(v Value)
+}
+
+// called in the loop goroutine of the connection for each command, it must not
+// block
+type HandlerFunc func(w ReplyWriter, cmd *Command)
+
+// Server dispatches RESP commands of a TCPServer to handlers, both RESP2 and
+// RESP3 clients are supported, see HELLO. inline commands are also accepted.
+// PING, ECHO, HELLO, QUIT and COMMAND are handled by default
+type Server interface {
+	// name is case insensitive, a handler of the same name is replaced
+	HandleFunc(name string, h HandlerFunc)
+
+	// larger bulk strings are protocol errors, the default is 512MB
+	SetMaxBulkSize(n int)
+
+	// start the TCPServer
+	Start() error
+
+	TCPServer() goreactor.TCPServer
+}
+
+type server struct {
+	tcpServer goreactor.TCPServer
+
+	handlers    map[string]HandlerFunc
+	maxBulkSize int
+}
+
+// the connection callback and the message callback of tcpServer are taken over
+func NewServer(tcpServer goreactor.TCPServer) Server {
+	s := &server{
+		tcpServer:   tcpServer,
+		handlers:    make(map[string]HandlerFunc),
+		maxBulkSize: 512 * 1024 * 1024,
+	}
+	s.handlers["PING"] = handlePing
+	s.handlers["ECHO"] = handleEcho
+	s.handlers["HELLO"] = handleHello
+	s.handlers["QUIT"] = handleQuit
+	s.handlers["COMMAND"] = handleCommand
+	tcpServer.SetConnectionCallback(s.onConnection)
+	tcpServer.SetMessageCallback(s.onMessage)
+	return s
+}
+
+func (s *server) HandleFunc(name string, h HandlerFunc) {
+	if h == nil {
+		panic("nil handler")
+	}
+	s.handlers[strings.ToUpper(name)] = h
+}
+
+func (s *server) SetMaxBulkSize(n int) {
+	if n < 0 {
+		panic("invalid max bulk size")
+	}
+	s.maxBulkSize = n
+}
+
+func (s *server) Start() error {
+	return s.tcpServer.Start()
+}
+
+func (s *server) TCPServer() goreactor.TCPServer {
+	return s.tcpServer
+}
+
+func (s *server) onConnection(conn goreactor.TCPConnection) {
+	cs := &connState{server: s, conn: conn}
+	conn.SetContext(contextKey, cs)
+	conn.SetDisConnectedCallback(func(goreactor.TCPConnection) {
+		cs.closed = true
+		cs.pending = nil
+	})
+}
+
+func (s *server) onMessage(conn goreactor.TCPConnection, buf buffer.Buffer) {
+	cs := conn.MustGetContext(contextKey).(*connState)
+	if cs.closing {
+		buf.RetrieveAll()
+		return
+	}
+
+	cs.buf = buf
+	cs.parse()
+}
+
+type replyWriter struct {
+	cs *connState
+
+	replied atomic.Bool
+
+	// fields below are only be accessed in loop goroutine
+
+	value Value
+	ready bool
+
+	// the protocol of the connection when the command is received
+	resp3 bool
+
+	// the connection is closed after the reply is sent
+	closeAfter bool
+}
+
+func (w *replyWriter) Reply(v Value) {
+	if !w.replied.CompareAndSwap(false, true) {
+		panic("command is already replied")
+	}
+
+	w.cs.conn.GetEventLoop().RunInLoop(func() {
+		w.value = v
+		w.ready = true
+		w.cs.flush()
+	})
+}
+
+// connState parses commands of a connection and sends replies in order, it is
+// only accessed in the loop goroutine
+type connState struct {
+	server *server
+	conn   goreactor.TCPConnection
+	buf    buffer.Buffer
+
+	// switched by HELLO
+	resp3 bool
+
+	// the command array being received
+	scanner scanner
+
+	// replies in the order of commands
+	pending []*replyWriter
+
+	// no more commands are parsed, the connection is closed after pending
+	// replies are sent
+	closing bool
+	closed  bool
+
+	parsing    bool
+	readPaused bool
+}
+
+// parses commands in the buffer, replies are sent together after that
+func (cs *connState) parse() {
+	for {
+		cs.parsing = true
+		for cs.parseCommand() {
+		}
+		cs.parsing = false
+		cs.writeReplies()
+
+		if !cs.readPaused || len(cs.pending) >= maxPipelined || cs.closing || cs.closed {
+			return
+		}
+		cs.readPaused = false
+		cs.conn.ResumeRead()
+	}
+}
+
+// returns false if more bytes are needed or no more commands can be parsed
+func (cs *connState) parseCommand() bool {
+	if cs.closing || cs.closed {
+		return false
+	}
+	if len(cs.pending) >= maxPipelined {
+		if !cs.readPaused {
+			cs.readPaused = true
+			cs.conn.PauseRead()
+		}
+		return false
+	}
+
+	data := cs.buf.Peek()
+	if len(data) == 0 {
+		return false
+	}
+
+	var args [][]byte
+	if data[0] == byte(TypeArray) {
+		n, err := cs.scanner.scan(data, cs.server.maxBulkSize)
+		if err != nil {
+			cs.fail(err.Error())
+			return false
+		}
+		if n == 0 {
+			return false
+		}
+		v, _, err := parse(data[:n], cs.server.maxBulkSize, 0)
+		if err != nil {
+			cs.fail(err.Error())
+			return false
+		}
+		cs.buf.Retrieve(n)
+
+		for _, e := range v.Elems {
+			if e.Type != TypeBulkString {
+				cs.fail("protocol error: commands must be arrays of bulk strings")
+				return false
+			}
+			args = append(args, e.Str)
+		}
+	} else {
+		// an inline command, for example typed in telnet
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if len(data) > maxLineSize {
+				cs.fail("protocol error: inline command is too long")
+			}
+			return false
+		}
+		for _, f := range strings.Fields(string(data[:end])) {
+			args = append(args, []byte(f))
+		}
+		cs.buf.Retrieve(end + 1)
+	}
+
+	// empty commands are ignored
+	if len(args) != 0 {
+		cs.dispatch(args)
+	}
+	return true
+}
+
+func (cs *connState) dispatch(args [][]byte) {
+	cmd := &Command{Name: strings.ToUpper(string(args[0])), Args: args[1:], Conn: cs.conn}
+	w := &replyWriter{cs: cs, resp3: cs.resp3}
+	cs.pending = append(cs.pending, w)
+
+	h, ok := cs.server.handlers[cmd.Name]
+	if !ok {
+		w.Reply(Error("ERR unknown command '" + string(args[0]) + "'"))
+		return
+	}
+	h(w, cmd)
+}
+
+// replies an error and closes the connection, commands before it are still
+// replied
+func (cs *connState) fail(msg string) {
+	cs.closing = true
+	cs.buf.RetrieveAll()
+
+	w := &replyWriter{cs: cs, resp3: cs.resp3, closeAfter: true}
+	w.replied.Store(true)
+	w.value = Error("ERR " + msg)
+	w.ready = true
+	cs.pending = append(cs.pending, w)
+}
+
+func (cs *connState) flush() {
+	// parse sends replies when it returns
+	if cs.parsing || cs.closed {
+		return
+	}
+	cs.parse()
+}
+
+// sends replies which are ready and are not waiting for previous ones
+func (cs *connState) writeReplies() {
+	if cs.closed {
+		return
+	}
+
+	var out []byte
+	shutdown := false
+	for len(cs.pending) != 0 && cs.pending[0].ready {
+		w := cs.pending[0]
+		cs.pending[0] = nil
+		cs.pending = cs.pending[1:]
+		out = w.value.AppendTo(out, w.resp3)
+
+		if w.closeAfter {
+			cs.closing = true
+			cs.pending = nil
+			shutdown = true
+		}
+	}
+
+	if len(out) != 0 {
+		cs.conn.SendOwned(out)
+	}
+	if shutdown {
+		cs.conn.ShutdownWrite()
+	}
+}
+
+func handlePing(w ReplyWriter, cmd *Command) {
+	switch len(cmd.Args) {
+	case 0:
+		w.Reply(SimpleString("PONG"))
+	case 1:
+		w.Reply(BulkString(cmd.Args[0]))
+	default:
+		w.Reply(WrongNumberOfArgs(cmd))
+	}
+}
+
+func handleEcho(w ReplyWriter, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		w.Reply(WrongNumberOfArgs(cmd))
+		return
+	}
+	w.Reply(BulkString(cmd.Args[0]))
+}
+
+// HELLO [protover], AUTH and SETNAME are not supported
+func handleHello(w ReplyWriter, cmd *Command) {
+	rw := w.(*replyWriter)
+	if len(cmd.Args) > 1 {
+		w.Reply(Error("ERR AUTH and SETNAME are not supported"))
+		return
+	}
+	if len(cmd.Args) == 1 {
+		switch string(cmd.Args[0]) {
+		case "2":
+			rw.cs.resp3 = false
+		case "3":
+			rw.cs.resp3 = true
+		default:
+			w.Reply(Error("NOPROTO unsupported protocol version"))
+			return
+		}
+		rw.resp3 = rw.cs.resp3
+	}
+
+	proto := int64(2)
+	if rw.cs.resp3 {
+		proto = 3
+	}
+	w.Reply(Map(
+		BulkString([]byte("server")), BulkString([]byte("go-reactor")),
+		BulkString([]byte("proto")), Integer(proto),
+		BulkString([]byte("id")), Integer(int64(cmd.Conn.GetFD())),
+		BulkString([]byte("mode")), BulkString([]byte("standalone")),
+		BulkString([]byte("role")), BulkString([]byte("master")),
+		BulkString([]byte("modules")), Array(),
+	))
+}
+
+func handleQuit(w ReplyWriter, cmd *Command) {
+	rw := w.(*replyWriter)
+	rw.closeAfter = true
+	rw.cs.closing = true
+	w.Reply(SimpleString("OK"))
+}
+
+// clients like redis-cli send it when they connect, no command is documented
+func handleCommand(w ReplyWriter, cmd *Command) {
+	w.Reply(Array())
+}
+
+// the error for a wrong number of arguments, handlers can use it
+func WrongNumberOfArgs(cmd *Command) Value {
+	return Error("ERR wrong number of arguments for '" + strings.ToLower(cmd.Name) + "' command")
+}
+
+// the value of an integer argument, ok is false if it is not an integer
+func ParseInt(arg []byte) (n int64, ok bool) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,206 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+)
+
+// Type is the first byte of a value, see
+// https://redis.io/docs/latest/develop/reference/protocol-spec/
+type Type byte
+
+const (
+	TypeSimpleString Type = '+'
+	TypeError        Type = '-'
+	TypeInteger      Type = ':'
+	TypeBulkString   Type = '$'
+	TypeArray        Type = '*'
+
+	// RESP3 types, they are converted to RESP2 types when they are written for
+	// RESP2 clients
+	TypeNull           Type = '_'
+	TypeBoolean        Type = '#'
+	TypeDouble         Type = ','
+	TypeBigNumber      Type = '('
+	TypeBulkError      Type = '!'
+	TypeVerbatimString Type = '='
+	TypeMap            Type = '%'
+	TypeSet            Type = '~'
+	TypePush           Type = '>'
+)
+
+type Value struct {
+	Type Type
+
+	// simple strings, errors, bulk strings, bulk errors, big numbers and verbatim
+	// strings, the latter includes the format like "txt:". simple strings and
+	// errors must not contain CR or LF
+	Str []byte
+
+	Int   int64
+	Bool  bool
+	Float float64
+
+	// arrays, sets, pushes, and maps whose keys and values are interleaved
+	Elems []Value
+}
+
+func SimpleString(s string) Value {
+	return Value{Type: TypeSimpleString, Str: []byte(s)}
+}
+
+// s starts with the error code like "ERR"
+func Error(s string) Value {
+	return Value{Type: TypeError, Str: []byte(s)}
+}
+
+func Integer(n int64) Value {
+	return Value{Type: TypeInteger, Int: n}
+}
+
+func BulkString(bs []byte) Value {
+	return Value{Type: TypeBulkString, Str: bs}
+}
+
+func Array(elems ...Value) Value {
+	return Value{Type: TypeArray, Elems: elems}
+}
+
+// a null bulk string for RESP2 clients
+func Null() Value {
+	return Value{Type: TypeNull}
+}
+
+func Boolean(b bool) Value {
+	return Value{Type: TypeBoolean, Bool: b}
+}
+
+func Double(f float64) Value {
+	return Value{Type: TypeDouble, Float: f}
+}
+
+// keys and values are interleaved
+func Map(kvs ...Value) Value {
+	if len(kvs)%2 != 0 {
+		panic("odd number of map elements")
+	}
+	return Value{Type: TypeMap, Elems: kvs}
+}
+
+// true for simple errors and bulk errors
+func (v Value) IsError() bool {
+	return v.Type == TypeError || v.Type == TypeBulkError
+}
+
+// appends the serialized value to dst, RESP3 types are converted to RESP2 ones if
+// resp3 is false
+func (v Value) AppendTo(dst []byte, resp3 bool) []byte {
+	switch v.Type {
+	case TypeSimpleString, TypeError:
+		return appendLine(dst, byte(v.Type), v.Str)
+	case TypeInteger:
+		return appendInt(dst, ':', v.Int)
+	case TypeBulkString:
+		return appendBulk(dst, '$', v.Str)
+	case TypeArray:
+		return appendElems(dst, '*', v.Elems, resp3)
+	case TypeNull:
+		if resp3 {
+			return append(dst, "_\r\n"...)
+		}
+		return append(dst, "$-1\r\n"...)
+	case TypeBoolean:
+		if resp3 {
+			if v.Bool {
+				return append(dst, "#t\r\n"...)
+			}
+			return append(dst, "#f\r\n"...)
+		}
+		if v.Bool {
+			return append(dst, ":1\r\n"...)
+		}
+		return append(dst, ":0\r\n"...)
+	case TypeDouble:
+		s := formatDouble(v.Float)
+		if resp3 {
+			return appendLine(dst, ',', []byte(s))
+		}
+		return appendBulk(dst, '$', []byte(s))
+	case TypeBigNumber:
+		if resp3 {
+			return appendLine(dst, '(', v.Str)
+		}
+		return appendBulk(dst, '$', v.Str)
+	case TypeBulkError:
+		if resp3 {
+			return appendBulk(dst, '!', v.Str)
+		}
+		// simple errors can not contain CR or LF
+		return appendLine(dst, '-', bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return ' '
+			}
+			return r
+		}, v.Str))
+	case TypeVerbatimString:
+		if resp3 {
+			return appendBulk(dst, '=', v.Str)
+		}
+		return appendBulk(dst, '$', v.Str[4:])
+	case TypeMap:
+		if resp3 {
+			dst = appendInt(dst, '%', int64(len(v.Elems)/2))
+			for _, e := range v.Elems {
+				dst = e.AppendTo(dst, resp3)
+			}
+			return dst
+		}
+		return appendElems(dst, '*', v.Elems, resp3)
+	case TypeSet, TypePush:
+		if resp3 {
+			return appendElems(dst, byte(v.Type), v.Elems, resp3)
+		}
+		return appendElems(dst, '*', v.Elems, resp3)
+	}
+
+	panic("invalid type " + strconv.Itoa(int(v.Type)))
+}
+
+func appendLine(dst []byte, prefix byte, s []byte) []byte {
+	dst = append(dst, prefix)
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendInt(dst []byte, prefix byte, n int64) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
+
+func appendBulk(dst []byte, prefix byte, s []byte) []byte {
+	dst = appendInt(dst, prefix, int64(len(s)))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendElems(dst []byte, prefix byte, elems []Value, resp3 bool) []byte {
+	dst = appendInt(dst, prefix, int64(len(elems)))
+	for _, e := range elems {
+		dst = e.AppendTo(dst, resp3)
+	}
+	return dst
+}
+
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
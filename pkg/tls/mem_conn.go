@@ -0,0 +1,116 @@
+package reactortls
+
+import (
+	"net"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+// returned by memConn.Read if all received ciphertext is consumed. crypto/tls
+// keeps partial records and does not break the connection on temporary errors,
+// so tls.Conn.Read can be called again when more ciphertext is received
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "reactortls: ciphertext is not received yet" }
+func (wouldBlockError) Timeout() bool   { return true }
+func (wouldBlockError) Temporary() bool { return true }
+
+var errWouldBlock net.Error = wouldBlockError{}
+
+// memConn is the net.Conn given to crypto/tls, it never touches the socket and
+// never blocks. it is only used in loop goroutine and the handshake coroutine,
+// which never run at the same time, see tlsConnection.startHandshake
+type memConn struct {
+	conn goreactor.TCPConnection
+
+	// ciphertext received, it is the input buffer of conn given to the message
+	// callback
+	in buffer.Buffer
+
+	// not nil while the handshake coroutine runs, Read suspends the coroutine
+	// with it until more ciphertext is received
+	yield func(struct{}) bool
+
+	// ciphertext written by the handshake coroutine, conn.Send does not run in
+	// place out of loop goroutine, so it is sent by flush in loop goroutine
+	out []byte
+
+	closed bool
+}
+
+func newMemConn(conn goreactor.TCPConnection) *memConn {
+	return &memConn{conn: conn}
+}
+
+func (mc *memConn) Read(b []byte) (int, error) {
+	for mc.in == nil || mc.in.ReadableBytes() == 0 {
+		if mc.closed {
+			return 0, net.ErrClosed
+		}
+		if mc.yield == nil {
+			return 0, errWouldBlock
+		}
+		// false means the connection is closed and the coroutine is stopped
+		if !mc.yield(struct{}{}) {
+			return 0, net.ErrClosed
+		}
+	}
+
+	n := copy(b, mc.in.Peek())
+	mc.in.Retrieve(n)
+	return n, nil
+}
+
+// crypto/tls reuses b, conn.Send copies it or writes it to the socket in place
+func (mc *memConn) Write(b []byte) (int, error) {
+	if mc.closed {
+		return 0, net.ErrClosed
+	}
+
+	if mc.yield != nil {
+		mc.out = append(mc.out, b...)
+		return len(b), nil
+	}
+
+	mc.conn.Send(b)
+	return len(b), nil
+}
+
+// be called in loop goroutine after the handshake coroutine is suspended
+func (mc *memConn) flush() {
+	if len(mc.out) != 0 {
+		out := mc.out
+		mc.out = nil
+		mc.conn.SendOwned(out)
+	}
+}
+
+func (mc *memConn) Close() error {
+	mc.closed = true
+	mc.in = nil
+	mc.out = nil
+	return nil
+}
+
+func (mc *memConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (mc *memConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(mc.conn.GetRemoteAddrPort())
+}
+
+// deadlines are implemented with loop timers, see SetHandshakeTimeout
+func (mc *memConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (mc *memConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (mc *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
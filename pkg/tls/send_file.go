@@ -0,0 +1,121 @@
+package reactortls
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// file segments are read and encrypted in chunks of it
+	fileChunkSize = 64 * 1024
+
+	// at most so many bytes of a file are encrypted into the output buffer, the
+	// rest is sent after they are flushed, so large files are not loaded into
+	// memory
+	fileBurstSize = 4 * fileChunkSize
+)
+
+// plaintext, or a file segment if fd is not -1
+type pendingSegment struct {
+	data []byte
+
+	fd        int
+	offset    int64
+	remaining int64
+}
+
+// sendfile(2) can not be used since the bytes must be encrypted, the segment is
+// read and encrypted in chunks in loop goroutine when the output buffer is
+// flushed. f is duplicated, it can be closed after the call
+func (tc *tlsConnection) SendFile(f *os.File, offset int64, length int64) error {
+	if offset < 0 || length < 0 {
+		panic("negative offset or length")
+	}
+	if length == 0 {
+		return nil
+	}
+
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+
+	tc.GetEventLoop().RunInLoop(func() {
+		if tc.closed {
+			syscall.Close(fd)
+			return
+		}
+
+		tc.pending = append(tc.pending, &pendingSegment{fd: fd, offset: offset, remaining: length})
+		if tc.handshakeDone && len(tc.pending) == 1 {
+			tc.pump()
+		}
+	})
+	return nil
+}
+
+// encrypts the pending segments in order, it stops at a file segment after
+// fileBurstSize bytes of it and continues in the write complete callback
+func (tc *tlsConnection) pump() {
+	burst := 0
+	for len(tc.pending) != 0 && !tc.closed {
+		seg := tc.pending[0]
+		if seg.fd == -1 {
+			tc.tlsConn.Write(seg.data)
+			tc.popPending()
+			continue
+		}
+
+		if burst >= fileBurstSize {
+			tc.waitingDrain = true
+			return
+		}
+
+		if tc.fileBuf == nil {
+			tc.fileBuf = make([]byte, fileChunkSize)
+		}
+		chunk := int(min(seg.remaining, int64(len(tc.fileBuf))))
+		n, err := syscall.Pread(seg.fd, tc.fileBuf[:chunk], seg.offset)
+		if err == syscall.EINTR {
+			continue
+		}
+		// the file is truncated or can not be read, the peer would wait for the
+		// missing bytes forever
+		if err != nil || n == 0 {
+			tc.ForceClose()
+			return
+		}
+
+		tc.tlsConn.Write(tc.fileBuf[:n])
+		burst += n
+		seg.offset += int64(n)
+		seg.remaining -= int64(n)
+		if seg.remaining == 0 {
+			syscall.Close(seg.fd)
+			tc.popPending()
+		}
+	}
+
+	tc.fileBuf = nil
+	if tc.shutdownQueued && !tc.closed {
+		tc.shutdownQueued = false
+		tc.shutdownWriteInLoop()
+	}
+}
+
+func (tc *tlsConnection) popPending() {
+	tc.pending[0] = nil
+	tc.pending = tc.pending[1:]
+}
+
+// the connection is closed, file segments not sent are closed
+func (tc *tlsConnection) releasePending() {
+	for _, seg := range tc.pending {
+		if seg.fd != -1 {
+			syscall.Close(seg.fd)
+		}
+	}
+	tc.pending = nil
+	tc.fileBuf = nil
+}
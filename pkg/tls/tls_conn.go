@@ -0,0 +1,338 @@
+package reactortls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"iter"
+	"sync"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+)
+
+type TLSConnection interface {
+	goreactor.TCPConnection
+
+	// returns false before the handshake is completed
+	IsHandshakeComplete() bool
+
+	// valid after the handshake is completed
+	ConnectionState() tls.ConnectionState
+
+	// ALPN result, empty if not negotiated
+	GetNegotiatedProtocol() string
+
+	// SNI sent by the client, empty if not sent
+	GetServerName() string
+
+	// certificates sent by the client, the first one is the leaf
+	GetPeerCertificates() []*x509.Certificate
+}
+
+// tlsConnection wraps a TCPConnection, Send encrypts plaintext and the message
+// callback only sees plaintext. records are decrypted by crypto/tls in the
+// message callback and encrypted records are sent to the output buffer in place
+type tlsConnection struct {
+	goreactor.TCPConnection
+
+	server *tlsServer
+
+	mc      *memConn
+	tlsConn *tls.Conn
+
+	// only be accessed in loop goroutine
+	handshakeDone bool
+	closed        bool
+
+	// close_notify or a bad record is received, no more plaintext
+	readClosed bool
+
+	// not nil while the handshake is in progress, see startHandshake
+	handshakeNext func() (struct{}, bool)
+	handshakeStop func()
+	handshakeErr  error
+
+	// plaintext and file segments sent before the handshake is completed or
+	// behind a file being streamed, see pump
+	pending []*pendingSegment
+
+	// the head file segment waits for the output buffer to be flushed
+	waitingDrain bool
+
+	// ShutdownWrite is called while segments are pending
+	shutdownQueued bool
+
+	// be used to read file segments, released when they are sent
+	fileBuf []byte
+
+	// plaintext decrypted but not consumed by the message callback
+	inputBuffer buffer.Buffer
+
+	// mu protects state, it may be read from any goroutine, tls.Conn.ConnectionState
+	// can not be used because it blocks during the handshake
+	mu    sync.Mutex
+	state tls.ConnectionState
+
+	// 0 means no handshake timer
+	handshakeTimerID int
+
+	disconnectedCallback  goreactor.DisConnectedCallbackFunc
+	highWaterCallback     goreactor.HighWaterCallbackFunc
+	lowWaterCallback      goreactor.LowWaterCallbackFunc
+	writeCompleteCallback goreactor.WriteCompleteCallbackFunc
+}
+
+func newTLSConnection(server *tlsServer, conn goreactor.TCPConnection) *tlsConnection {
+	mc := newMemConn(conn)
+	tc := &tlsConnection{
+		TCPConnection:         conn,
+		server:                server,
+		mc:                    mc,
+		tlsConn:               tls.Server(mc, server.config),
+		inputBuffer:           buffer.NewBuffer(),
+		disconnectedCallback:  func(goreactor.TCPConnection) {},
+		highWaterCallback:     func(goreactor.TCPConnection, int) {},
+		lowWaterCallback:      func(goreactor.TCPConnection, int) {},
+		writeCompleteCallback: func(goreactor.TCPConnection) {},
+	}
+
+	conn.SetDisConnectedCallback(func(goreactor.TCPConnection) {
+		tc.handleClose()
+	})
+	conn.SetHighWaterCallback(func(_ goreactor.TCPConnection, sz int) {
+		tc.highWaterCallback(tc, sz)
+	})
+	conn.SetLowWaterCallback(func(_ goreactor.TCPConnection, sz int) {
+		tc.lowWaterCallback(tc, sz)
+	})
+	conn.SetWriteCompleteCallback(func(goreactor.TCPConnection) {
+		// the flushed bytes are a chunk of a file, the rest is sent now
+		if tc.waitingDrain {
+			tc.waitingDrain = false
+			tc.pump()
+			return
+		}
+		tc.writeCompleteCallback(tc)
+	})
+
+	return tc
+}
+
+func (tc *tlsConnection) SetDisConnectedCallback(f goreactor.DisConnectedCallbackFunc) {
+	tc.disconnectedCallback = f
+}
+
+// the size is the size of ciphertext
+func (tc *tlsConnection) SetHighWaterCallback(f goreactor.HighWaterCallbackFunc) {
+	tc.highWaterCallback = f
+}
+
+// the size is the size of ciphertext
+func (tc *tlsConnection) SetLowWaterCallback(f goreactor.LowWaterCallbackFunc) {
+	tc.lowWaterCallback = f
+}
+
+func (tc *tlsConnection) SetPeerClosedWriteCallback(f goreactor.PeerClosedWriteCallbackFunc) {
+	if f == nil {
+		tc.TCPConnection.SetPeerClosedWriteCallback(nil)
+		return
+	}
+
+	tc.TCPConnection.SetPeerClosedWriteCallback(func(goreactor.TCPConnection) {
+		f(tc)
+	})
+}
+
+func (tc *tlsConnection) SetWriteCompleteCallback(f goreactor.WriteCompleteCallbackFunc) {
+	tc.writeCompleteCallback = f
+}
+
+// bs is copied, it may be queued or sent from another goroutine after the call
+func (tc *tlsConnection) Send(bs []byte) {
+	tc.send(append([]byte(nil), bs...))
+}
+
+// the slices are not copied, the caller must not modify them after the call
+func (tc *tlsConnection) SendV(bss [][]byte) {
+	for _, bs := range bss {
+		tc.send(bs)
+	}
+}
+
+func (tc *tlsConnection) SendOwned(bs []byte) {
+	tc.send(bs)
+}
+
+// plaintext sent before the handshake is completed or behind a file segment is
+// queued
+func (tc *tlsConnection) send(bs []byte) {
+	tc.GetEventLoop().RunInLoop(func() {
+		if tc.closed {
+			return
+		}
+
+		if !tc.handshakeDone || len(tc.pending) != 0 {
+			tc.pending = append(tc.pending, &pendingSegment{data: bs, fd: -1})
+			return
+		}
+
+		// the records are sent in place, see memConn.Write
+		tc.tlsConn.Write(bs)
+	})
+}
+
+// sends close_notify and then shutdown write of the socket, after the pending
+// segments are sent if the handshake is completed
+func (tc *tlsConnection) ShutdownWrite() {
+	tc.GetEventLoop().RunInLoop(func() {
+		if tc.closed {
+			return
+		}
+
+		if tc.handshakeDone && len(tc.pending) != 0 {
+			tc.shutdownQueued = true
+			return
+		}
+		tc.shutdownWriteInLoop()
+	})
+}
+
+func (tc *tlsConnection) shutdownWriteInLoop() {
+	if tc.handshakeDone {
+		tc.tlsConn.CloseWrite()
+	}
+	tc.TCPConnection.ShutdownWrite()
+}
+
+func (tc *tlsConnection) IsHandshakeComplete() bool {
+	return tc.ConnectionState().HandshakeComplete
+}
+
+func (tc *tlsConnection) ConnectionState() tls.ConnectionState {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.state
+}
+
+func (tc *tlsConnection) GetNegotiatedProtocol() string {
+	return tc.ConnectionState().NegotiatedProtocol
+}
+
+func (tc *tlsConnection) GetServerName() string {
+	return tc.ConnectionState().ServerName
+}
+
+func (tc *tlsConnection) GetPeerCertificates() []*x509.Certificate {
+	return tc.ConnectionState().PeerCertificates
+}
+
+// crypto/tls can not resume a handshake after a read fails, so the handshake
+// runs in a coroutine. it is suspended when it needs more ciphertext and
+// resumed by the message callback, the coroutine and loop goroutine run in turn,
+// so the loop is never blocked. be called in loop goroutine
+func (tc *tlsConnection) startHandshake() {
+	tc.handshakeNext, tc.handshakeStop = iter.Pull(func(yield func(struct{}) bool) {
+		tc.mc.yield = yield
+		tc.handshakeErr = tc.tlsConn.Handshake()
+		tc.mc.yield = nil
+	})
+	tc.resumeHandshake()
+}
+
+func (tc *tlsConnection) resumeHandshake() {
+	_, suspended := tc.handshakeNext()
+	// it may close the connection
+	tc.mc.flush()
+	if suspended || tc.closed {
+		return
+	}
+
+	tc.handshakeNext, tc.handshakeStop = nil, nil
+	if tc.handshakeErr != nil {
+		// the alert is sent by flush
+		tc.ForceClose()
+		return
+	}
+	tc.onHandshakeComplete()
+}
+
+// be called in loop goroutine with ciphertext
+func (tc *tlsConnection) handleCiphertext(buf buffer.Buffer) {
+	if tc.readClosed {
+		buf.RetrieveAll()
+		return
+	}
+
+	tc.mc.in = buf
+	if tc.handshakeNext != nil {
+		tc.resumeHandshake()
+	}
+	if !tc.handshakeDone || tc.closed {
+		return
+	}
+
+	// the client may send application data right after its Finished
+	tc.readPlaintext()
+}
+
+// decrypts all complete records received, the extra buffer of loop is free in
+// the message callback
+func (tc *tlsConnection) readPlaintext() {
+	extrabuf := tc.GetEventLoop().GetExtraData()
+	received := false
+	for !tc.readClosed {
+		n, err := tc.tlsConn.Read(extrabuf)
+		if n > 0 {
+			tc.inputBuffer.Append(extrabuf[:n])
+			received = true
+		}
+		if err == errWouldBlock {
+			break
+		}
+		if err != nil {
+			// close_notify received or a bad record, both mean no more plaintext
+			tc.readClosed = true
+		}
+	}
+
+	if received && !tc.closed {
+		tc.server.msgCallback(tc, tc.inputBuffer)
+	}
+	// after the callback, so it can reply to the last plaintext
+	if tc.readClosed {
+		tc.ShutdownWrite()
+	}
+}
+
+func (tc *tlsConnection) onHandshakeComplete() {
+	if tc.handshakeTimerID != 0 {
+		tc.GetEventLoop().CancelTimer(tc.handshakeTimerID)
+		tc.handshakeTimerID = 0
+	}
+
+	tc.mu.Lock()
+	tc.state = tc.tlsConn.ConnectionState()
+	tc.mu.Unlock()
+
+	tc.handshakeDone = true
+	tc.pump()
+
+	tc.server.handshakeCompleteCallback(tc)
+}
+
+func (tc *tlsConnection) handleClose() {
+	tc.closed = true
+	tc.releasePending()
+	if tc.handshakeTimerID != 0 {
+		tc.GetEventLoop().CancelTimer(tc.handshakeTimerID)
+		tc.handshakeTimerID = 0
+	}
+
+	tc.mc.Close()
+	// makes the coroutine return, its Read fails
+	if tc.handshakeStop != nil {
+		tc.handshakeStop()
+		tc.handshakeNext, tc.handshakeStop = nil, nil
+	}
+	tc.disconnectedCallback(tc)
+}
@@ -0,0 +1,108 @@
+package reactortls
+
+import (
+	"crypto/tls"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+	"github.com/markity/go-reactor/pkg/codec"
+)
+
+// context key of the inner TCPConnection, be used to find the tlsConnection
+const tlsConnectionContextKey = "__reactortls_conn"
+
+type HandshakeCompleteCallbackFunc func(TLSConnection)
+
+type TLSServer interface {
+	goreactor.TCPServer
+
+	// called after the handshake is completed, ALPN, SNI and peer certificates
+	// are available after that
+	SetHandshakeCompleteCallback(f HandshakeCompleteCallbackFunc)
+
+	// connections which do not complete the handshake in d are closed, 0 means
+	// no timeout, the default is 10s. must be called before Start
+	SetHandshakeTimeout(d time.Duration)
+}
+
+type tlsServer struct {
+	goreactor.TCPServer
+
+	config *tls.Config
+
+	handshakeTimeout time.Duration
+
+	connectedCallback         goreactor.ConnectedCallbackFunc
+	msgCallback               goreactor.MessageCallbackFunc
+	handshakeCompleteCallback HandshakeCompleteCallbackFunc
+}
+
+// wraps server, the connections given to callbacks are TLSConnection, their
+// Send encrypts and the message callback only sees plaintext. config must
+// contain at least one certificate, see tls.Config
+func NewTLSServer(server goreactor.TCPServer, config *tls.Config) TLSServer {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil &&
+		config.GetConfigForClient == nil) {
+		panic("tls config has no certificate")
+	}
+
+	ts := &tlsServer{
+		TCPServer:                 server,
+		config:                    config,
+		handshakeTimeout:          time.Second * 10,
+		connectedCallback:         func(goreactor.TCPConnection) {},
+		msgCallback:               func(_ goreactor.TCPConnection, buf buffer.Buffer) { buf.RetrieveAll() },
+		handshakeCompleteCallback: func(TLSConnection) {},
+	}
+	server.SetConnectionCallback(ts.onConnection)
+	server.SetMessageCallback(ts.onMessage)
+
+	return ts
+}
+
+// called when the tcp connection is established, before the handshake
+func (ts *tlsServer) SetConnectionCallback(f goreactor.ConnectedCallbackFunc) {
+	ts.connectedCallback = f
+}
+
+// the buffer contains plaintext
+func (ts *tlsServer) SetMessageCallback(f goreactor.MessageCallbackFunc) {
+	ts.msgCallback = f
+}
+
+// frames are decoded from plaintext, the connection is force closed if dec fails
+func (ts *tlsServer) SetFrameCallback(dec codec.Decoder, maxFrameSize int, f goreactor.FrameCallbackFunc) {
+	ts.msgCallback = goreactor.NewFrameMessageCallback(dec, maxFrameSize, f)
+}
+
+func (ts *tlsServer) SetHandshakeCompleteCallback(f HandshakeCompleteCallbackFunc) {
+	ts.handshakeCompleteCallback = f
+}
+
+func (ts *tlsServer) SetHandshakeTimeout(d time.Duration) {
+	ts.handshakeTimeout = d
+}
+
+func (ts *tlsServer) onConnection(conn goreactor.TCPConnection) {
+	tc := newTLSConnection(ts, conn)
+	conn.SetContext(tlsConnectionContextKey, tc)
+
+	if ts.handshakeTimeout > 0 {
+		tc.handshakeTimerID = conn.GetEventLoop().RunAt(time.Now().Add(ts.handshakeTimeout), 0, func(timerID int) {
+			tc.handshakeTimerID = 0
+			if !tc.handshakeDone {
+				tc.ForceClose()
+			}
+		})
+	}
+
+	ts.connectedCallback(tc)
+	if !tc.closed {
+		tc.startHandshake()
+	}
+}
+
+func (ts *tlsServer) onMessage(conn goreactor.TCPConnection, buf buffer.Buffer) {
+	conn.MustGetContext(tlsConnectionContextKey).(*tlsConnection).handleCiphertext(buf)
+}
@@ -0,0 +1,431 @@
+package reactortls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+	"github.com/markity/go-reactor/pkg/codec"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+func selfSignedCert(t *testing.T, cn string, usage x509.ExtKeyUsage) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, leaf
+}
+
+// the server echoes plaintext, setup can replace callbacks before Start
+func startEchoServer(t *testing.T, config *tls.Config, setup func(TLSServer)) string {
+	loop := eventloop.NewEventLoop()
+	server := NewTLSServer(goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin()), config)
+	server.SetMessageCallback(func(c goreactor.TCPConnection, buf buffer.Buffer) {
+		c.Send([]byte(buf.RetrieveAsString()))
+	})
+	if setup != nil {
+		setup(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	return server.GetListenAddrPort().String()
+}
+
+func clientConfig(leaf *x509.Certificate) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return &tls.Config{RootCAs: pool, ServerName: leaf.Subject.CommonName}
+}
+
+func echo(t *testing.T, conn net.Conn, payload []byte) {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		errc <- err
+	}()
+
+	got := make([]byte, len(payload))
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echoed bytes differ")
+	}
+}
+
+func TestEchoWithALPNAndSNI(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	states := make(chan tls.ConnectionState, 1)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"echo"}},
+		func(s TLSServer) {
+			s.SetHandshakeCompleteCallback(func(c TLSConnection) {
+				if !c.IsHandshakeComplete() {
+					t.Error("handshake is not complete in the callback")
+				}
+				states <- c.ConnectionState()
+			})
+		})
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		config := clientConfig(leaf)
+		config.NextProtos = []string{"echo"}
+		config.MinVersion, config.MaxVersion = version, version
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := <-states
+		if state.Version != version || state.NegotiatedProtocol != "echo" || state.ServerName != "reactor.test" {
+			t.Fatalf("unexpected state: version %x alpn %q sni %q", state.Version, state.NegotiatedProtocol, state.ServerName)
+		}
+
+		// many records, some of them are split across reads
+		payload := make([]byte, 4<<20)
+		rand.Read(payload)
+		echo(t, conn, payload)
+		conn.Close()
+	}
+}
+
+// writes one byte per write, so the server receives records in pieces
+type trickleConn struct {
+	net.Conn
+}
+
+func (c trickleConn) Write(b []byte) (int, error) {
+	for i := range b {
+		if _, err := c.Conn.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+	return len(b), nil
+}
+
+func TestHandshakeAcrossPartialReads(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, nil)
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	conn := tls.Client(trickleConn{raw}, clientConfig(leaf))
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn, []byte("hello over a slow link"))
+}
+
+func TestClientCertificates(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	clientCert, clientLeaf := selfSignedCert(t, "client", x509.ExtKeyUsageClientAuth)
+	peers := make(chan []*x509.Certificate, 1)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert},
+		func(s TLSServer) {
+			s.SetHandshakeCompleteCallback(func(c TLSConnection) {
+				peers <- c.GetPeerCertificates()
+			})
+		})
+
+	config := clientConfig(leaf)
+	config.Certificates = []tls.Certificate{clientCert}
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, []byte("ping"))
+
+	certs := <-peers
+	if len(certs) != 1 || !certs[0].Equal(clientLeaf) {
+		t.Fatal("unexpected peer certificates")
+	}
+}
+
+func TestSendBeforeHandshakeIsQueued(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(s TLSServer) {
+		s.SetConnectionCallback(func(c goreactor.TCPConnection) {
+			c.Send([]byte("welcome\n"))
+		})
+	})
+
+	conn, err := tls.Dial("tcp", addr, clientConfig(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got := make([]byte, len("welcome\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "welcome\n" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestCloseNotifyShutsDownWrite(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, nil)
+
+	conn, err := tls.Dial("tcp", addr, clientConfig(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "bye" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func expectClosed(t *testing.T, conn net.Conn, within time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(within))
+	_, err := io.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("the connection is not closed")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	cert, _ := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(s TLSServer) {
+		s.SetHandshakeTimeout(100 * time.Millisecond)
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectClosed(t, conn, 5*time.Second)
+}
+
+func TestInvalidClientHelloClosesConnection(t *testing.T) {
+	cert, _ := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, nil)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: reactor.test\r\n\r\n"))
+	expectClosed(t, conn, 5*time.Second)
+}
+
+// the handshake coroutine returns after the handshake, established connections
+// do not keep goroutines
+func TestNoGoroutinePerConnection(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, nil)
+
+	before := runtime.NumGoroutine()
+	var conns []net.Conn
+	for i := 0; i < 50; i++ {
+		conn, err := tls.Dial("tcp", addr, clientConfig(leaf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn, []byte("x"))
+		conns = append(conns, conn)
+	}
+	after := runtime.NumGoroutine()
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	if after-before > 5 {
+		t.Fatalf("%d goroutines for %d connections", after-before, len(conns))
+	}
+}
+
+// the file is encrypted in chunks when the output buffer is flushed, the output
+// buffer limit closes the connection if it is loaded at once
+func TestSendFileIsStreamedInOrder(t *testing.T) {
+	content := make([]byte, 20<<20)
+	rand.Read(content)
+	f, err := os.CreateTemp(t.TempDir(), "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(content)
+	defer f.Close()
+
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(s TLSServer) {
+		s.SetHandshakeCompleteCallback(func(c TLSConnection) {
+			c.SetMaxOutputBufferSize(1<<20, goreactor.OutputOverflowClose)
+			c.Send([]byte("head"))
+			if err := c.SendFile(f, 1000, int64(len(content)-1000)); err != nil {
+				t.Error(err)
+			}
+			c.Send([]byte("tail"))
+			c.ShutdownWrite()
+		})
+	})
+
+	conn, err := tls.Dial("tcp", addr, clientConfig(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte("head"), content[1000:]...), "tail"...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// a proxy forwards a plain connection to a TLS connection, reading the plain one
+// is paused while the TLS client does not read
+func TestBackpressureFromTLSDownstream(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	downstreams := make(chan TLSConnection, 1)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(s TLSServer) {
+		s.SetHandshakeCompleteCallback(func(c TLSConnection) {
+			downstreams <- c
+		})
+	})
+	client, err := tls.Dial("tcp", addr, clientConfig(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	down := <-downstreams
+
+	loop := eventloop.NewEventLoop()
+	upstreams := make(chan goreactor.TCPConnection, 1)
+	source := goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin())
+	source.SetConnectionCallback(func(c goreactor.TCPConnection) {
+		c.SetBackpressure(down, 256<<10, 64<<10)
+		upstreams <- c
+	})
+	source.SetMessageCallback(func(c goreactor.TCPConnection, buf buffer.Buffer) {
+		down.Send(buf.Peek())
+		buf.RetrieveAll()
+	})
+	if err := source.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	raw, err := net.Dial("tcp", source.GetListenAddrPort().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	payload := make([]byte, 16<<20)
+	rand.Read(payload)
+	go raw.Write(payload)
+
+	up := <-upstreams
+	for deadline := time.Now().Add(5 * time.Second); !up.IsReadPaused(); {
+		if time.Now().After(deadline) {
+			t.Fatal("the upstream is not paused")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := make([]byte, len(payload))
+	client.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("forwarded bytes differ")
+	}
+	if up.IsReadPaused() {
+		t.Fatal("the upstream is not resumed")
+	}
+}
+
+// a frame larger than the limit closes the TLS connection with the decoder error
+func TestFrameErrorClosesConnection(t *testing.T) {
+	cert, leaf := selfSignedCert(t, "reactor.test", x509.ExtKeyUsageServerAuth)
+	lastErrors := make(chan error, 1)
+	addr := startEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(s TLSServer) {
+		s.SetFrameCallback(codec.NewLineDecoder(), 16, func(c goreactor.TCPConnection, frame []byte) {
+			c.Send(append(frame, '\n'))
+		})
+		s.SetHandshakeCompleteCallback(func(c TLSConnection) {
+			c.SetDisConnectedCallback(func(c goreactor.TCPConnection) {
+				lastErrors <- c.GetLastError()
+			})
+		})
+	})
+	client, err := tls.Dial("tcp", addr, clientConfig(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	echo(t, client, []byte("short\n"))
+	client.Write(bytes.Repeat([]byte("x"), 64))
+	select {
+	case err := <-lastErrors:
+		if err != codec.ErrFrameTooLarge {
+			t.Fatalf("last error is %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection is not closed")
+	}
+}
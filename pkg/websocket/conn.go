@@ -0,0 +1,280 @@
+package websocket
+
+import (
+	"time"
+	"unicode/utf8"
+
+	goreactor "github.com/markity/go-reactor"
+	"github.com/markity/go-reactor/pkg/buffer"
+	reactorhttp "github.com/markity/go-reactor/pkg/http"
+)
+
+// the connection is closed if the peer does not finish the close handshake in it
+const closeTimeout = 5 * time.Second
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+type Conn interface {
+	// send a message in one frame, it can be called in any goroutine. messages
+	// sent after Close are dropped
+	SendMessage(typ MessageType, data []byte)
+	SendText(s string)
+
+	// start the close handshake, it can be called in any goroutine. code must be
+	// a code which can be sent, see RFC 6455 section 7.4, and the reason must not
+	// be longer than 123 bytes
+	Close(code int, reason string)
+
+	// the underlying connection, its context can be used to store data
+	TCPConnection() goreactor.TCPConnection
+
+	// the upgrade request
+	Request() *reactorhttp.Request
+
+	// the negotiated subprotocol, empty if not negotiated
+	Subprotocol() string
+}
+
+type conn struct {
+	server      *server
+	tcpConn     goreactor.TCPConnection
+	req         *reactorhttp.Request
+	subprotocol string
+
+	// fields below are only be accessed in loop goroutine
+
+	started bool
+	closed  bool
+
+	// a close frame is sent, no more frames are sent
+	closeSent bool
+
+	// the handshake is done or failed, input is discarded
+	closing bool
+
+	// the close frame received, CloseAbnormalClosure if not received
+	closeCode   int
+	closeReason string
+
+	// the opcode and payloads of a fragmented message, fragmentOp is 0 if there is
+	// no fragmented message
+	fragmentOp byte
+	fragments  []byte
+
+	// a frame is received since the last ping
+	alive bool
+
+	pingTimerID  int
+	closeTimerID int
+}
+
+func (c *conn) SendMessage(typ MessageType, data []byte) {
+	if typ != TextMessage && typ != BinaryMessage {
+		panic("invalid message type")
+	}
+
+	c.sendFrame(buildFrame(byte(typ), data))
+}
+
+func (c *conn) SendText(s string) {
+	c.SendMessage(TextMessage, []byte(s))
+}
+
+func (c *conn) Close(code int, reason string) {
+	if !isValidCloseCode(code) {
+		panic("invalid close code")
+	}
+	if len(reason) > maxControlPayload-2 {
+		panic("close reason is too long")
+	}
+
+	c.tcpConn.GetEventLoop().RunInLoop(func() {
+		if c.closed || c.closeSent {
+			return
+		}
+
+		c.sendClose(code, reason)
+	})
+}
+
+func (c *conn) TCPConnection() goreactor.TCPConnection {
+	return c.tcpConn
+}
+
+func (c *conn) Request() *reactorhttp.Request {
+	return c.req
+}
+
+func (c *conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// sendFrame can be called in any goroutine
+func (c *conn) sendFrame(bs []byte) {
+	c.tcpConn.GetEventLoop().RunInLoop(func() {
+		if c.closed || c.closeSent {
+			return
+		}
+
+		c.tcpConn.SendOwned(bs)
+	})
+}
+
+// be called in loop goroutine, the connection is closed when the peer replies or
+// after closeTimeout
+func (c *conn) sendClose(code int, reason string) {
+	c.closeSent = true
+	c.tcpConn.SendOwned(buildCloseFrame(code, reason))
+	c.closeTimerID = c.tcpConn.GetEventLoop().RunAt(time.Now().Add(closeTimeout), 0, func(int) {
+		c.closeTimerID = 0
+		c.tcpConn.ForceClose()
+	})
+}
+
+// the message callback after the upgrade, see reactorhttp.ResponseWriter.Upgrade
+func (c *conn) handleInput(_ goreactor.TCPConnection, buf buffer.Buffer) {
+	if !c.started {
+		c.start()
+	}
+
+	for !c.closed {
+		if c.closing {
+			buf.RetrieveAll()
+			return
+		}
+
+		f, n, code := parseFrame(buf.Peek(), c.server.maxMessageSize-len(c.fragments))
+		if code != 0 {
+			c.fail(code)
+			continue
+		}
+		if n == 0 {
+			return
+		}
+		buf.Retrieve(n)
+		c.alive = true
+		c.handleFrame(f)
+	}
+}
+
+func (c *conn) start() {
+	c.started = true
+	c.closeCode = CloseAbnormalClosure
+	c.tcpConn.SetDisConnectedCallback(c.handleClose)
+
+	if d := c.server.pingInterval; d > 0 {
+		c.alive = true
+		c.pingTimerID = c.tcpConn.GetEventLoop().RunAt(time.Now().Add(d), d, c.handlePingTimer)
+	}
+
+	c.server.connectedCallback(c)
+}
+
+func (c *conn) handleFrame(f frame) {
+	switch f.opcode {
+	case opPing:
+		if !c.closeSent {
+			c.tcpConn.SendOwned(buildFrame(opPong, f.payload))
+		}
+	case opPong:
+		// alive is already set
+	case opClose:
+		c.handleCloseFrame(f.payload)
+	case opText, opBinary:
+		if c.fragmentOp != 0 {
+			c.fail(CloseProtocolError)
+			return
+		}
+		if f.fin {
+			c.deliver(f.opcode, f.payload)
+			return
+		}
+		c.fragmentOp = f.opcode
+		c.fragments = f.payload
+	case opContinuation:
+		if c.fragmentOp == 0 {
+			c.fail(CloseProtocolError)
+			return
+		}
+		c.fragments = append(c.fragments, f.payload...)
+		if f.fin {
+			op, data := c.fragmentOp, c.fragments
+			c.fragmentOp = 0
+			c.fragments = nil
+			c.deliver(op, data)
+		}
+	}
+}
+
+func (c *conn) deliver(op byte, data []byte) {
+	if op == opText && !utf8.Valid(data) {
+		c.fail(CloseInvalidPayloadData)
+		return
+	}
+
+	// messages after the close frame is sent are dropped
+	if !c.closeSent {
+		c.server.messageCallback(c, MessageType(op), data)
+	}
+}
+
+func (c *conn) handleCloseFrame(payload []byte) {
+	code, reason, errCode := parseClosePayload(payload)
+	if errCode != 0 {
+		c.fail(errCode)
+		return
+	}
+
+	c.closeCode = code
+	c.closeReason = reason
+	if !c.closeSent {
+		c.sendClose(code, "")
+	}
+
+	// the server closes the TCP connection first, see RFC 6455 section 7.1.1
+	c.closing = true
+	c.tcpConn.ShutdownWrite()
+}
+
+// closes the connection because of an invalid frame
+func (c *conn) fail(code int) {
+	if !c.closeSent {
+		c.sendClose(code, "")
+	}
+	c.closing = true
+	c.fragments = nil
+	c.tcpConn.ShutdownWrite()
+}
+
+func (c *conn) handlePingTimer(int) {
+	if !c.alive {
+		c.tcpConn.ForceClose()
+		return
+	}
+
+	c.alive = false
+	if !c.closeSent {
+		c.tcpConn.SendOwned(buildFrame(opPing, nil))
+	}
+}
+
+func (c *conn) handleClose(goreactor.TCPConnection) {
+	c.closed = true
+	c.fragments = nil
+	loop := c.tcpConn.GetEventLoop()
+	if c.pingTimerID != 0 {
+		loop.CancelTimer(c.pingTimerID)
+		c.pingTimerID = 0
+	}
+	if c.closeTimerID != 0 {
+		loop.CancelTimer(c.closeTimerID)
+		c.closeTimerID = 0
+	}
+
+	c.server.closedCallback(c, c.closeCode, c.closeReason)
+}
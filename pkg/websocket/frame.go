@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// close codes, see RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure       = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatusReceived    = 1005
+	CloseAbnormalClosure     = 1006
+	CloseInvalidPayloadData  = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseMandatoryExtension  = 1010
+	CloseInternalServerError = 1011
+)
+
+// control frames can not be larger than it
+const maxControlPayload = 125
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// parses a frame sent by a client, the payload is unmasked into a new slice. n is
+// 0 if more bytes are needed, code is not 0 if the frame is invalid. maxPayload
+// is the max size of the payload of data frames
+func parseFrame(data []byte, maxPayload int) (f frame, n int, code int) {
+	if len(data) < 2 {
+		return frame{}, 0, 0
+	}
+
+	f.fin = data[0]&0x80 != 0
+	f.opcode = data[0] & 0x0f
+	// no extension is negotiated, so RSV bits must be 0
+	if data[0]&0x70 != 0 {
+		return frame{}, 0, CloseProtocolError
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !f.fin || data[1]&0x7f > maxControlPayload {
+			return frame{}, 0, CloseProtocolError
+		}
+	default:
+		return frame{}, 0, CloseProtocolError
+	}
+	// frames from clients must be masked
+	if data[1]&0x80 == 0 {
+		return frame{}, 0, CloseProtocolError
+	}
+
+	headerLen := 2
+	length := uint64(data[1] & 0x7f)
+	switch length {
+	case 126:
+		headerLen += 2
+		if len(data) < headerLen {
+			return frame{}, 0, 0
+		}
+		length = uint64(binary.BigEndian.Uint16(data[2:]))
+	case 127:
+		headerLen += 8
+		if len(data) < headerLen {
+			return frame{}, 0, 0
+		}
+		length = binary.BigEndian.Uint64(data[2:])
+		if length>>63 != 0 {
+			return frame{}, 0, CloseProtocolError
+		}
+	}
+	if length > uint64(maxPayload) && f.opcode < opClose {
+		return frame{}, 0, CloseMessageTooBig
+	}
+
+	headerLen += 4
+	if uint64(len(data)) < uint64(headerLen)+length {
+		return frame{}, 0, 0
+	}
+
+	key := data[headerLen-4 : headerLen]
+	f.payload = make([]byte, length)
+	for i := range f.payload {
+		f.payload[i] = data[headerLen+i] ^ key[i&3]
+	}
+	return f, headerLen + int(length), 0
+}
+
+// builds an unmasked frame, frames sent by servers are not masked
+func buildFrame(opcode byte, payload []byte) []byte {
+	var header [10]byte
+	header[0] = 0x80 | opcode
+	headerLen := 2
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		headerLen = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		headerLen = 10
+	}
+
+	bs := make([]byte, headerLen+len(payload))
+	copy(bs, header[:headerLen])
+	copy(bs[headerLen:], payload)
+	return bs
+}
+
+func buildCloseFrame(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return buildFrame(opClose, nil)
+	}
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return buildFrame(opClose, payload)
+}
+
+// parses the payload of a close frame, code is CloseNoStatusReceived if it is
+// empty. errCode is not 0 if it is invalid
+func parseClosePayload(payload []byte) (code int, reason string, errCode int) {
+	if len(payload) == 0 {
+		return CloseNoStatusReceived, "", 0
+	}
+	if len(payload) == 1 {
+		return 0, "", CloseProtocolError
+	}
+
+	code = int(binary.BigEndian.Uint16(payload))
+	if !isValidCloseCode(code) {
+		return 0, "", CloseProtocolError
+	}
+	if !utf8.Valid(payload[2:]) {
+		return 0, "", CloseInvalidPayloadData
+	}
+	return code, string(payload[2:]), 0
+}
+
+// codes which can be sent in a close frame
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	reactorhttp "github.com/markity/go-reactor/pkg/http"
+)
+
+// see RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// callbacks are called in the loop goroutine of the connection
+type ConnectedCallbackFunc func(Conn)
+type MessageCallbackFunc func(Conn, MessageType, []byte)
+
+// code is CloseNoStatusReceived if the close frame has no code, and
+// CloseAbnormalClosure if the connection is closed without a close frame
+type ClosedCallbackFunc func(c Conn, code int, reason string)
+
+// Server upgrades HTTP requests to WebSocket connections, see RFC 6455.
+// extensions are not supported
+type Server interface {
+	SetConnectedCallback(f ConnectedCallbackFunc)
+
+	// fragmented messages are reassembled, text messages are valid UTF-8
+	SetMessageCallback(f MessageCallbackFunc)
+
+	// called once after the connection is closed
+	SetClosedCallback(f ClosedCallbackFunc)
+
+	// larger messages close the connection with CloseMessageTooBig, the default is
+	// 1MB
+	SetMaxMessageSize(n int)
+
+	// a ping is sent each interval, the connection is closed if nothing is
+	// received during an interval. 0 disables it, the default is 30s
+	SetPingInterval(d time.Duration)
+
+	// the first one of them requested by the client is negotiated
+	SetSubprotocols(protocols ...string)
+
+	// requests rejected by it are responded with 403, the default accepts
+	// requests without Origin or with an Origin whose host is the Host header
+	SetCheckOrigin(f func(r *reactorhttp.Request) bool)
+
+	// validates the handshake of r and upgrades the connection, or responds with
+	// an error. it is usually called in the handler of a reactorhttp.Server
+	Upgrade(w reactorhttp.ResponseWriter, r *reactorhttp.Request)
+}
+
+type server struct {
+	connectedCallback ConnectedCallbackFunc
+	messageCallback   MessageCallbackFunc
+	closedCallback    ClosedCallbackFunc
+
+	maxMessageSize int
+	pingInterval   time.Duration
+	subprotocols   []string
+	checkOrigin    func(r *reactorhttp.Request) bool
+}
+
+func NewServer() Server {
+	return &server{
+		connectedCallback: func(Conn) {},
+		messageCallback:   func(Conn, MessageType, []byte) {},
+		closedCallback:    func(Conn, int, string) {},
+		maxMessageSize:    1024 * 1024,
+		pingInterval:      30 * time.Second,
+		checkOrigin:       sameOrigin,
+	}
+}
+
+func (s *server) SetConnectedCallback(f ConnectedCallbackFunc) {
+	s.connectedCallback = f
+}
+
+func (s *server) SetMessageCallback(f MessageCallbackFunc) {
+	s.messageCallback = f
+}
+
+func (s *server) SetClosedCallback(f ClosedCallbackFunc) {
+	s.closedCallback = f
+}
+
+func (s *server) SetMaxMessageSize(n int) {
+	if n <= 0 {
+		panic("invalid max message size")
+	}
+	s.maxMessageSize = n
+}
+
+func (s *server) SetPingInterval(d time.Duration) {
+	if d < 0 {
+		panic(d)
+	}
+	s.pingInterval = d
+}
+
+func (s *server) SetSubprotocols(protocols ...string) {
+	s.subprotocols = protocols
+}
+
+func (s *server) SetCheckOrigin(f func(r *reactorhttp.Request) bool) {
+	s.checkOrigin = f
+}
+
+func (s *server) Upgrade(w reactorhttp.ResponseWriter, r *reactorhttp.Request) {
+	if r.Method != "GET" || r.Proto != "HTTP/1.1" ||
+		!r.Header.HasToken("Upgrade", "websocket") || !r.Header.HasToken("Connection", "upgrade") {
+		w.WriteResponse(400, []byte("not a websocket handshake\n"))
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteResponse(426, []byte("unsupported websocket version\n"))
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		w.WriteResponse(400, []byte("invalid Sec-WebSocket-Key\n"))
+		return
+	}
+	if !s.checkOrigin(r) {
+		w.WriteResponse(403, []byte("origin not allowed\n"))
+		return
+	}
+
+	c := &conn{server: s, tcpConn: r.Conn, req: r, subprotocol: s.negotiate(r)}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	w.Header().Set("Upgrade", "websocket")
+	w.Header().Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	if c.subprotocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", c.subprotocol)
+	}
+	w.Upgrade(c.handleInput)
+}
+
+func (s *server) negotiate(r *reactorhttp.Request) string {
+	for _, p := range s.subprotocols {
+		if r.Header.HasToken("Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func sameOrigin(r *reactorhttp.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Header.Get("Host"))
+}
@@ -0,0 +1,404 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goreactor "github.com/markity/go-reactor"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+	reactorhttp "github.com/markity/go-reactor/pkg/http"
+)
+
+type closeEvent struct {
+	code   int
+	reason string
+}
+
+// the server echoes messages and reports closed connections to the returned
+// channel, setup can change settings and callbacks
+func startServer(t *testing.T, setup func(Server)) (string, chan closeEvent) {
+	closed := make(chan closeEvent, 16)
+	ws := NewServer()
+	ws.SetMessageCallback(func(c Conn, typ MessageType, data []byte) {
+		c.SendMessage(typ, data)
+	})
+	ws.SetClosedCallback(func(c Conn, code int, reason string) {
+		closed <- closeEvent{code, reason}
+	})
+	if setup != nil {
+		setup(ws)
+	}
+
+	loop := eventloop.NewEventLoop()
+	tcpServer := goreactor.NewTCPServer(loop, "127.0.0.1:0", 0, goreactor.RoundRobin())
+	httpServer := reactorhttp.NewServer(tcpServer)
+	httpServer.SetHandler(ws.Upgrade)
+	if err := httpServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	return tcpServer.GetListenAddrPort().String(), closed
+}
+
+type client struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// sends the handshake request with header lines, and returns the response
+func handshake(t *testing.T, addr string, header string) (*client, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req := "GET /chat HTTP/1.1\r\nHost: " + addr + "\r\n" + header + "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{conn, bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, resp
+}
+
+const validHeader = "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+
+func dial(t *testing.T, addr string) *client {
+	c, resp := handshake(t, addr, validHeader)
+	if resp.StatusCode != 101 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	return c
+}
+
+func (c *client) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte, masked bool) {
+	var bs []byte
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	bs = append(bs, b0)
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		bs = append(bs, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		bs = append(bs, maskBit|126)
+		bs = binary.BigEndian.AppendUint16(bs, uint16(len(payload)))
+	default:
+		bs = append(bs, maskBit|127)
+		bs = binary.BigEndian.AppendUint64(bs, uint64(len(payload)))
+	}
+
+	if !masked {
+		bs = append(bs, payload...)
+	} else {
+		var key [4]byte
+		rand.Read(key[:])
+		bs = append(bs, key[:]...)
+		for i, b := range payload {
+			bs = append(bs, b^key[i&3])
+		}
+	}
+	if _, err := c.Write(bs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) readFrame(t *testing.T) (fin bool, opcode byte, payload []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("frames from the server must not be masked")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0]&0x80 != 0, head[0] & 0x0f, payload
+}
+
+// expects the server to close the TCP connection, and closes it like a client
+// does after the close handshake
+func (c *client) expectEOF(t *testing.T) {
+	if rest, err := io.ReadAll(c.r); err != nil || len(rest) != 0 {
+		t.Fatalf("got %q, %v before EOF", rest, err)
+	}
+	c.Close()
+}
+
+// reads a close frame and expects the server to close the TCP connection
+func (c *client) expectClose(t *testing.T, code int) {
+	_, opcode, payload := c.readFrame(t)
+	if opcode != opClose {
+		t.Fatalf("opcode %x, want a close frame", opcode)
+	}
+	if len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		t.Fatalf("close payload %q, want code %d", payload, code)
+	}
+
+	c.expectEOF(t)
+}
+
+func expectEvent(t *testing.T, closed chan closeEvent, want closeEvent) {
+	select {
+	case got := <-closed:
+		if got != want {
+			t.Fatalf("closed callback got %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the closed callback is not called")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	addr, _ := startServer(t, func(s Server) {
+		s.SetSubprotocols("chat", "superchat")
+	})
+
+	// the example of RFC 6455 section 1.3
+	c, resp := handshake(t, addr, validHeader+"Sec-WebSocket-Protocol: superchat, chat\r\n")
+	if resp.StatusCode != 101 ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Protocol") != "chat" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	c.writeFrame(t, true, opText, []byte("hi"), true)
+	if _, opcode, payload := c.readFrame(t); opcode != opText || string(payload) != "hi" {
+		t.Fatalf("got %x %q", opcode, payload)
+	}
+
+	rejected := []struct {
+		header string
+		status int
+	}{
+		{"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n", 400},
+		{"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n", 426},
+		{"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: short\r\nSec-WebSocket-Version: 13\r\n", 400},
+		{validHeader + "Origin: http://evil.test\r\n", 403},
+	}
+	for _, r := range rejected {
+		if _, resp := handshake(t, addr, r.header); resp.StatusCode != r.status {
+			t.Fatalf("status %d, want %d", resp.StatusCode, r.status)
+		}
+	}
+}
+
+func TestEchoMaskedFrames(t *testing.T) {
+	addr, _ := startServer(t, nil)
+	c := dial(t, addr)
+
+	// lengths in 7 bits, 16 bits and 64 bits
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+		c.writeFrame(t, true, opBinary, payload, true)
+
+		fin, opcode, got := c.readFrame(t)
+		if !fin || opcode != opBinary || !bytes.Equal(got, payload) {
+			t.Fatalf("size %d: echoed frame differs", size)
+		}
+	}
+}
+
+func TestUnmaskedFrameIsRejected(t *testing.T) {
+	addr, closed := startServer(t, nil)
+	c := dial(t, addr)
+
+	c.writeFrame(t, true, opText, []byte("hi"), false)
+	c.expectClose(t, CloseProtocolError)
+	expectEvent(t, closed, closeEvent{CloseAbnormalClosure, ""})
+}
+
+func TestFragmentationWithInterleavedControlFrames(t *testing.T) {
+	addr, _ := startServer(t, nil)
+	c := dial(t, addr)
+
+	// "é" is split across fragments, the message is valid UTF-8 after reassembly
+	c.writeFrame(t, false, opText, []byte("caf\xc3"), true)
+	c.writeFrame(t, true, opPing, []byte("ping"), true)
+	if _, opcode, payload := c.readFrame(t); opcode != opPong || string(payload) != "ping" {
+		t.Fatalf("got %x %q, want a pong", opcode, payload)
+	}
+	c.writeFrame(t, false, opContinuation, []byte("\xa9 "), true)
+	c.writeFrame(t, true, opPong, nil, true)
+	c.writeFrame(t, true, opContinuation, []byte("au lait"), true)
+
+	if fin, opcode, payload := c.readFrame(t); !fin || opcode != opText || string(payload) != "café au lait" {
+		t.Fatalf("got %x %q", opcode, payload)
+	}
+
+	// a data frame can not interrupt a fragmented message
+	c.writeFrame(t, false, opBinary, []byte("a"), true)
+	c.writeFrame(t, true, opBinary, []byte("b"), true)
+	c.expectClose(t, CloseProtocolError)
+}
+
+func TestContinuationWithoutMessage(t *testing.T) {
+	addr, _ := startServer(t, nil)
+	c := dial(t, addr)
+
+	c.writeFrame(t, true, opContinuation, []byte("a"), true)
+	c.expectClose(t, CloseProtocolError)
+}
+
+func TestFragmentedControlFrame(t *testing.T) {
+	addr, _ := startServer(t, nil)
+	c := dial(t, addr)
+
+	c.writeFrame(t, false, opPing, []byte("a"), true)
+	c.expectClose(t, CloseProtocolError)
+}
+
+func TestPingTimer(t *testing.T) {
+	interval := 100 * time.Millisecond
+	addr, closed := startServer(t, func(s Server) {
+		s.SetPingInterval(interval)
+	})
+	c := dial(t, addr)
+
+	// pongs keep the connection alive
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, opcode, _ := c.readFrame(t); opcode != opPing {
+			t.Fatalf("opcode %x, want a ping", opcode)
+		}
+		c.writeFrame(t, true, opPong, nil, true)
+	}
+	if d := time.Since(start); d < 4*interval {
+		t.Fatalf("5 pings in %v", d)
+	}
+
+	// silent peers are closed without the close handshake
+	_, opcode, _ := c.readFrame(t)
+	if opcode != opPing {
+		t.Fatalf("opcode %x, want a ping", opcode)
+	}
+	c.expectEOF(t)
+	expectEvent(t, closed, closeEvent{CloseAbnormalClosure, ""})
+}
+
+func TestClientInitiatedClose(t *testing.T) {
+	addr, closed := startServer(t, nil)
+	c := dial(t, addr)
+
+	payload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	c.writeFrame(t, true, opClose, append(payload, "bye"...), true)
+	c.expectClose(t, CloseGoingAway)
+	expectEvent(t, closed, closeEvent{CloseGoingAway, "bye"})
+}
+
+func TestServerInitiatedClose(t *testing.T) {
+	addr, closed := startServer(t, func(s Server) {
+		s.SetMessageCallback(func(c Conn, typ MessageType, data []byte) {
+			c.Close(CloseNormalClosure, "done")
+			// dropped after Close
+			c.SendText("late")
+		})
+	})
+	c := dial(t, addr)
+
+	c.writeFrame(t, true, opText, []byte("hi"), true)
+	_, opcode, payload := c.readFrame(t)
+	if opcode != opClose || string(payload[2:]) != "done" {
+		t.Fatalf("got %x %q, want a close frame", opcode, payload)
+	}
+
+	// messages after the close frame are dropped
+	c.writeFrame(t, true, opText, []byte("ignored"), true)
+	c.writeFrame(t, true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormalClosure), true)
+	c.expectEOF(t)
+	expectEvent(t, closed, closeEvent{CloseNormalClosure, ""})
+}
+
+func TestCloseWithoutCode(t *testing.T) {
+	addr, closed := startServer(t, nil)
+	c := dial(t, addr)
+
+	c.writeFrame(t, true, opClose, nil, true)
+	_, opcode, payload := c.readFrame(t)
+	if opcode != opClose || len(payload) != 0 {
+		t.Fatalf("got %x %q, want an empty close frame", opcode, payload)
+	}
+	c.expectEOF(t)
+	expectEvent(t, closed, closeEvent{CloseNoStatusReceived, ""})
+}
+
+func TestOversizeMessage(t *testing.T) {
+	addr, _ := startServer(t, func(s Server) {
+		s.SetMaxMessageSize(16)
+	})
+
+	c := dial(t, addr)
+	c.writeFrame(t, true, opBinary, make([]byte, 16), true)
+	if _, _, payload := c.readFrame(t); len(payload) != 16 {
+		t.Fatalf("got %d bytes", len(payload))
+	}
+	c.writeFrame(t, true, opBinary, make([]byte, 17), true)
+	c.expectClose(t, CloseMessageTooBig)
+
+	// the limit applies to reassembled messages
+	c = dial(t, addr)
+	c.writeFrame(t, false, opBinary, make([]byte, 10), true)
+	c.writeFrame(t, true, opContinuation, make([]byte, 10), true)
+	c.expectClose(t, CloseMessageTooBig)
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	addr, _ := startServer(t, nil)
+
+	c := dial(t, addr)
+	c.writeFrame(t, true, opText, []byte("bad \xff"), true)
+	c.expectClose(t, CloseInvalidPayloadData)
+
+	c = dial(t, addr)
+	c.writeFrame(t, false, opText, []byte("caf\xc3"), true)
+	c.writeFrame(t, true, opContinuation, []byte("("), true)
+	c.expectClose(t, CloseInvalidPayloadData)
+
+	// the reason of a close frame must be UTF-8 too
+	c = dial(t, addr)
+	payload := binary.BigEndian.AppendUint16(nil, CloseNormalClosure)
+	c.writeFrame(t, true, opClose, append(payload, 0xff), true)
+	c.expectClose(t, CloseInvalidPayloadData)
+}
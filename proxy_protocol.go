@@ -0,0 +1,276 @@
+package goreactor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// the connection does not start with a valid PROXY protocol header
+	ErrInvalidProxyHeader = errors.New("goreactor: invalid PROXY protocol header")
+
+	// the PROXY protocol header is not received in time, see
+	// TCPServer.SetProxyProtocol
+	ErrProxyHeaderTimeout = errors.New("goreactor: PROXY protocol header timeout")
+)
+
+type ProxyCommand byte
+
+const (
+	// sent by the proxy itself, for example health checks, the addresses of the
+	// connection are not overridden. v1 UNKNOWN headers are also LOCAL
+	ProxyCommandLocal ProxyCommand = 0
+	// relayed for a client
+	ProxyCommandProxy ProxyCommand = 1
+)
+
+// TLV types of PROXY protocol v2, see section 2.2 of
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is the PROXY protocol header received at the beginning of a
+// connection, see TCPServer.SetProxyProtocol
+type ProxyHeader struct {
+	// 1 or 2
+	Version int
+
+	Command ProxyCommand
+
+	// the client address and the address the client connected to, zero values
+	// for LOCAL commands and unix socket addresses
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+
+	// only be used by v2
+	TLVs []ProxyTLV
+}
+
+// the value of the first TLV of typ
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// the max size of a v1 header including CRLF
+const maxProxyV1Size = 107
+
+// parses the header at the beginning of data, n is the size of it, or 0 if more
+// bytes are needed
+func parseProxyHeader(data []byte) (h *ProxyHeader, n int, err error) {
+	if len(data) == 0 {
+		return nil, 0, nil
+	}
+
+	sig := proxyV2Signature
+	if data[0] == 'P' {
+		sig = proxyV1Prefix
+	}
+	if !bytes.HasPrefix(sig, data[:min(len(data), len(sig))]) {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if len(data) < len(sig) {
+		return nil, 0, nil
+	}
+
+	if data[0] == 'P' {
+		return parseProxyV1(data)
+	}
+	return parseProxyV2(data)
+}
+
+// PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n
+func parseProxyV1(data []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(data[:min(len(data), maxProxyV1Size)], []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= maxProxyV1Size {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		return nil, 0, nil
+	}
+
+	h := &ProxyHeader{Version: 1, Command: ProxyCommandProxy}
+	fields := strings.Split(string(data[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Command = ProxyCommandLocal
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	src, err1 := netip.ParseAddr(fields[2])
+	dst, err2 := netip.ParseAddr(fields[3])
+	sport, err3 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err4 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	is4 := fields[1] == "TCP4"
+	if src.Is4() != is4 || dst.Is4() != is4 {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	h.Source = netip.AddrPortFrom(src, uint16(sport))
+	h.Destination = netip.AddrPortFrom(dst, uint16(dport))
+	return h, end + 2, nil
+}
+
+// the signature, version and command, family and protocol, the length of
+// addresses and TLVs, see section 2.2 of the spec
+func parseProxyV2(data []byte) (*ProxyHeader, int, error) {
+	if len(data) < 16 {
+		return nil, 0, nil
+	}
+	size := 16 + int(binary.BigEndian.Uint16(data[14:]))
+	if len(data) < size {
+		return nil, 0, nil
+	}
+
+	if data[12]>>4 != 2 || data[12]&0x0f > 1 {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	h := &ProxyHeader{Version: 2, Command: ProxyCommand(data[12] & 0x0f)}
+
+	payload := data[16:size]
+	addrLen := 0
+	switch data[13] >> 4 {
+	case 0x0:
+		// AF_UNSPEC
+	case 0x1:
+		addrLen = 12
+		if len(payload) >= addrLen {
+			h.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])),
+				binary.BigEndian.Uint16(payload[8:]))
+			h.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])),
+				binary.BigEndian.Uint16(payload[10:]))
+		}
+	case 0x2:
+		addrLen = 36
+		if len(payload) >= addrLen {
+			h.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])),
+				binary.BigEndian.Uint16(payload[32:]))
+			h.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])),
+				binary.BigEndian.Uint16(payload[34:]))
+		}
+	case 0x3:
+		// AF_UNIX, the addresses are paths
+		addrLen = 216
+	default:
+		return nil, 0, ErrInvalidProxyHeader
+	}
+	if len(payload) < addrLen {
+		return nil, 0, ErrInvalidProxyHeader
+	}
+
+	for tlvs := payload[addrLen:]; len(tlvs) != 0; {
+		if len(tlvs) < 3 || len(tlvs) < 3+int(binary.BigEndian.Uint16(tlvs[1:])) {
+			return nil, 0, ErrInvalidProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:]))
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: append([]byte{}, tlvs[3:3+l]...)})
+
+		// the checksum is computed over the whole header with the value zeroed
+		if tlvs[0] == ProxyTLVCRC32C {
+			if l != 4 {
+				return nil, 0, ErrInvalidProxyHeader
+			}
+			header := append([]byte{}, data[:size]...)
+			off := size - len(tlvs) + 3
+			copy(header[off:off+4], []byte{0, 0, 0, 0})
+			if crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli)) != binary.BigEndian.Uint32(tlvs[3:]) {
+				return nil, 0, ErrInvalidProxyHeader
+			}
+		}
+		tlvs = tlvs[3+l:]
+	}
+
+	// the addresses of LOCAL commands must be ignored
+	if h.Command == ProxyCommandLocal {
+		h.Source = netip.AddrPort{}
+		h.Destination = netip.AddrPort{}
+	}
+	h.Source = netip.AddrPortFrom(h.Source.Addr().Unmap(), h.Source.Port())
+	h.Destination = netip.AddrPortFrom(h.Destination.Addr().Unmap(), h.Destination.Port())
+	return h, size, nil
+}
+
+// be called in establishConn, the connected callback is delayed until the header
+// is received
+func (conn *tcpConnection) startProxyHeaderTimer() {
+	conn.awaitingProxyHeader = true
+	conn.proxyTimerID = conn.loop.RunAt(time.Now().Add(conn.proxyHeaderTimeout), 0, func(int) {
+		conn.proxyTimerID = 0
+		if conn.state != Disconnected {
+			conn.lastError = ErrProxyHeaderTimeout
+			conn.handleClose(DisconnectProxyHeaderError)
+		}
+	})
+}
+
+// be called instead of the message callback until the header is received
+func (conn *tcpConnection) handleProxyHeader() {
+	h, n, err := parseProxyHeader(conn.inputBuffer.Peek())
+	if err != nil {
+		conn.lastError = err
+		conn.handleClose(DisconnectProxyHeaderError)
+		return
+	}
+	if n == 0 {
+		return
+	}
+
+	conn.inputBuffer.Retrieve(n)
+	conn.awaitingProxyHeader = false
+	conn.cancelProxyHeaderTimer()
+	conn.proxyHeader = h
+	if h.Command == ProxyCommandProxy && h.Source.IsValid() {
+		conn.remoteAddrPort.Store(&h.Source)
+	}
+
+	conn.acquireIPBuckets()
+	conn.connectedCallback(conn)
+	// bytes after the header
+	if conn.state != Disconnected && conn.inputBuffer.ReadableBytes() != 0 {
+		conn.countMessage()
+		conn.messageCallback(conn, conn.inputBuffer)
+	}
+}
+
+func (conn *tcpConnection) cancelProxyHeaderTimer() {
+	if conn.proxyTimerID != 0 {
+		conn.loop.CancelTimer(conn.proxyTimerID)
+		conn.proxyTimerID = 0
+	}
+}
+
+func (conn *tcpConnection) GetProxyHeader() *ProxyHeader {
+	return conn.proxyHeader
+}
@@ -0,0 +1,121 @@
+package goreactor
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markity/go-reactor/pkg/buffer"
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// the remote address is read in another goroutine while the PROXY protocol
+// header replaces it, run with -race
+func TestRemoteAddrPortReplacedByProxyHeader(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	server := NewTCPServer(loop, "127.0.0.1:0", 1, RoundRobin()).(*tcpServer)
+	server.SetProxyProtocol(5 * time.Second)
+	connected := make(chan TCPConnection, 1)
+	server.SetConnectionCallback(func(c TCPConnection) {
+		connected <- c
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	client, err := net.Dial("tcp", server.GetListenAddrPort().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the connected callback is not called before the header, the connection
+	// is taken from the server
+	var conn *tcpConnection
+	for deadline := time.Now().Add(5 * time.Second); conn == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("the connection is not accepted")
+		}
+		server.mu.Lock()
+		for c := range server.conns {
+			conn = c
+		}
+		server.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if !conn.GetRemoteAddrPort().IsValid() {
+					t.Error("remote address is invalid")
+					return
+				}
+			}
+		}
+	}()
+
+	client.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	c := <-connected
+	close(done)
+	wg.Wait()
+
+	if got := c.GetRemoteAddrPort(); got != netip.MustParseAddrPort("192.0.2.1:56324") {
+		t.Fatalf("remote address is %v", got)
+	}
+	if got := c.GetLocalAddrPort(); got != netip.MustParseAddrPort("192.0.2.2:443") {
+		t.Fatalf("local address is %v", got)
+	}
+}
+
+// the PROXY protocol header is not a message, bytes after it in the same read are
+func TestProxyHeaderIsNotCountedAsMessage(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	server := NewTCPServer(loop, "127.0.0.1:0", 0, RoundRobin())
+	server.SetProxyProtocol(5 * time.Second)
+	connected := make(chan TCPConnection, 1)
+	server.SetConnectionCallback(func(c TCPConnection) {
+		connected <- c
+	})
+	received := make(chan string, 2)
+	server.SetMessageCallback(func(c TCPConnection, buf buffer.Buffer) {
+		received <- buf.RetrieveAsString()
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	for _, rest := range []string{"", "hello"} {
+		client, err := net.Dial("tcp", server.GetListenAddrPort().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n" + rest))
+
+		c := <-connected
+		want := uint64(0)
+		if rest != "" {
+			if got := <-received; got != rest {
+				t.Fatalf("received %q", got)
+			}
+			want = 1
+		}
+		if got := c.Stats().Messages; got != want {
+			t.Fatalf("%d messages, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package goreactor
+
+import (
+	"math"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// a throttled connection resumes when the buckets refill to this many bytes, or
+// to the burst if it is smaller, so it is not woken up for every few bytes
+const minRateLimitChunk = 4096
+
+// tokenBucket allows rate tokens per second with bursts of burst tokens. reads
+// can not be split, so tokens may go negative after a read, the debt delays the
+// next one and keeps the average rate. buckets of remote IPs are shared by
+// connections in different loops, mu protects them
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// the bucket is full at the beginning
+func newTokenBucket(rate int, burst int) *tokenBucket {
+	if rate <= 0 || burst <= 0 {
+		panic("invalid rate or burst")
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// b.mu must be held
+func (b *tokenBucket) refillLocked(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// tokens available now, and how long to wait until the bucket has enough tokens
+// to resume if it has none
+func (b *tokenBucket) available(now time.Time) (int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(now)
+	if b.tokens >= 1 {
+		return int(b.tokens), 0
+	}
+
+	want := math.Min(b.burst, minRateLimitChunk)
+	return 0, time.Duration((want - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n int, now time.Time) {
+	b.mu.Lock()
+	b.refillLocked(now)
+	b.tokens -= float64(n)
+	b.mu.Unlock()
+}
+
+// true if the bucket has a token, the token is taken
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// the budget of buckets, math.MaxInt if there is no bucket. wait is not 0 if
+// the budget is 0
+func budgetOf(now time.Time, buckets ...*tokenBucket) (budget int, wait time.Duration) {
+	budget = math.MaxInt
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		n, w := b.available(now)
+		budget = min(budget, n)
+		wait = max(wait, w)
+	}
+	return budget, wait
+}
+
+func takeFrom(n int, now time.Time, buckets ...*tokenBucket) {
+	for _, b := range buckets {
+		if b != nil {
+			b.take(n, now)
+		}
+	}
+}
+
+// buckets shared by connections from the same remote IP
+type ipBuckets struct {
+	read  *tokenBucket
+	write *tokenBucket
+	refs  int
+}
+
+// ipLimiter owns the buckets of remote IPs of a server, a bucket is released when
+// the last connection of its IP is closed
+type ipLimiter struct {
+	mu sync.Mutex
+
+	// 0 means no limit
+	readRate   int
+	readBurst  int
+	writeRate  int
+	writeBurst int
+
+	buckets map[netip.Addr]*ipBuckets
+}
+
+func (l *ipLimiter) acquire(addr netip.Addr) *ipBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &ipBuckets{}
+		if l.readRate != 0 {
+			b.read = newTokenBucket(l.readRate, l.readBurst)
+		}
+		if l.writeRate != 0 {
+			b.write = newTokenBucket(l.writeRate, l.writeBurst)
+		}
+		l.buckets[addr] = b
+	}
+	b.refs++
+	return b
+}
+
+func (l *ipLimiter) release(addr netip.Addr, b *ipBuckets) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b.refs--
+	if b.refs == 0 {
+		delete(l.buckets, addr)
+	}
+}
+
+// acceptLimiter limits new connections of each remote IP in the accept path
+type acceptLimiter struct {
+	mu    sync.Mutex
+	rate  int
+	burst int
+
+	buckets map[netip.Addr]*tokenBucket
+
+	// full buckets are dropped when the map grows to it, see allow
+	sweepAt int
+}
+
+func (l *acceptLimiter) allow(addr netip.Addr) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		// a full bucket behaves like no bucket, so they can be dropped to bound the
+		// map by the IPs connecting recently
+		if len(l.buckets) >= l.sweepAt {
+			for a, b := range l.buckets {
+				if n, _ := b.available(now); n >= l.burst {
+					delete(l.buckets, a)
+				}
+			}
+			l.sweepAt = max(1024, 2*len(l.buckets))
+		}
+
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[addr] = b
+	}
+	return b.allow(now)
+}
+
+func (conn *tcpConnection) SetReadLimit(bytesPerSecond int, burst int) {
+	var b *tokenBucket
+	if bytesPerSecond != 0 {
+		b = newTokenBucket(bytesPerSecond, burst)
+	}
+	conn.loop.RunInLoop(func() {
+		conn.readLimit = b
+	})
+}
+
+func (conn *tcpConnection) SetWriteLimit(bytesPerSecond int, burst int) {
+	var b *tokenBucket
+	if bytesPerSecond != 0 {
+		b = newTokenBucket(bytesPerSecond, burst)
+	}
+	conn.loop.RunInLoop(func() {
+		conn.writeLimit = b
+	})
+}
+
+// be called right before the connected callback, after the PROXY protocol header
+// which may change the remote address
+func (conn *tcpConnection) acquireIPBuckets() {
+	addr := conn.GetRemoteAddrPort().Addr()
+	if conn.ipLimiter == nil || !addr.IsValid() {
+		return
+	}
+	conn.ipBuckets = conn.ipLimiter.acquire(addr)
+}
+
+func (conn *tcpConnection) releaseIPBuckets() {
+	if conn.ipBuckets != nil {
+		conn.ipLimiter.release(conn.GetRemoteAddrPort().Addr(), conn.ipBuckets)
+		conn.ipBuckets = nil
+	}
+}
+
+func (conn *tcpConnection) readBuckets() (*tokenBucket, *tokenBucket) {
+	if conn.ipBuckets == nil {
+		return conn.readLimit, nil
+	}
+	return conn.readLimit, conn.ipBuckets.read
+}
+
+func (conn *tcpConnection) writeBuckets() (*tokenBucket, *tokenBucket) {
+	if conn.ipBuckets == nil {
+		return conn.writeLimit, nil
+	}
+	return conn.writeLimit, conn.ipBuckets.write
+}
+
+// the bytes which can be read now, math.MaxInt if there is no limit. if it is
+// 0, reading is disabled until the buckets refill
+func (conn *tcpConnection) readBudget() int {
+	connBucket, ipBucket := conn.readBuckets()
+	if connBucket == nil && ipBucket == nil {
+		return math.MaxInt
+	}
+
+	budget, wait := budgetOf(time.Now(), connBucket, ipBucket)
+	if budget > 0 {
+		return budget
+	}
+
+	conn.readThrottled = true
+	if conn.socketChannel.DisableRead() {
+		conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+	}
+	conn.readTimerID = conn.loop.RunAt(time.Now().Add(wait), 0, func(int) {
+		conn.readTimerID = 0
+		conn.readThrottled = false
+		if conn.state != Disconnected && conn.readPauses == 0 && !conn.peerClosedWrite {
+			if conn.socketChannel.EnableRead() {
+				conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+			}
+		}
+	})
+	return 0
+}
+
+// like Buffer.ReadFD but reads limit bytes at most, so a limited connection does
+// not take more than its budget in one read
+func (conn *tcpConnection) readLimited(limit int) (int, error) {
+	extrabuf := conn.loop.GetExtraData()
+	n, err := syscall.Read(conn.socketChannel.GetFD(), extrabuf[:min(limit, len(extrabuf))])
+	if n > 0 {
+		conn.inputBuffer.Append(extrabuf[:n])
+	}
+	return max(n, 0), err
+}
+
+func (conn *tcpConnection) consumeReadBudget(n int) {
+	connBucket, ipBucket := conn.readBuckets()
+	if connBucket != nil || ipBucket != nil {
+		takeFrom(n, time.Now(), connBucket, ipBucket)
+	}
+}
+
+// the bytes which can be written now, math.MaxInt if there is no limit. if it
+// is 0, writing is deferred until the buckets refill
+func (conn *tcpConnection) writeBudget() int {
+	if conn.writeThrottled {
+		return 0
+	}
+	connBucket, ipBucket := conn.writeBuckets()
+	if connBucket == nil && ipBucket == nil {
+		return math.MaxInt
+	}
+
+	budget, wait := budgetOf(time.Now(), connBucket, ipBucket)
+	if budget > 0 {
+		return budget
+	}
+
+	// the writable event is not wanted until the timer fires, the output is still
+	// pending, see isWritePending
+	conn.writeThrottled = true
+	if !conn.socketChannel.IsEdgeTriggered() && conn.socketChannel.DisableWrite() {
+		conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+	}
+	conn.writeTimerID = conn.loop.RunAt(time.Now().Add(wait), 0, func(int) {
+		conn.writeTimerID = 0
+		conn.writeThrottled = false
+		if conn.state != Disconnected && conn.hasPendingOutput() {
+			conn.startWrite(true)
+		}
+	})
+	return 0
+}
+
+func (conn *tcpConnection) consumeWriteBudget(n int) {
+	connBucket, ipBucket := conn.writeBuckets()
+	if connBucket != nil || ipBucket != nil {
+		takeFrom(n, time.Now(), connBucket, ipBucket)
+	}
+}
+
+// be called in handleClose
+func (conn *tcpConnection) releaseRateLimits() {
+	if conn.readTimerID != 0 {
+		conn.loop.CancelTimer(conn.readTimerID)
+		conn.readTimerID = 0
+	}
+	if conn.writeTimerID != 0 {
+		conn.loop.CancelTimer(conn.writeTimerID)
+		conn.writeTimerID = 0
+	}
+	conn.releaseIPBuckets()
+}
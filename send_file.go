@@ -0,0 +1,88 @@
+package goreactor
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// sendfile(2) transfers at most 0x7ffff000 bytes each call
+const maxSendFileChunk = 0x7ffff000
+
+func (conn *tcpConnection) SendFile(f *os.File, offset int64, length int64) error {
+	if offset < 0 || length < 0 {
+		panic("negative offset or length")
+	}
+	if length == 0 {
+		return nil
+	}
+
+	// duplicate in the caller goroutine, f may be closed after SendFile returns
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+
+	conn.loop.RunInLoop(func() {
+		if conn.state != Connected {
+			syscall.Close(fd)
+			return
+		}
+
+		idle := !conn.hasPendingOutput()
+		conn.outputQueue = append(conn.outputQueue, &outputSegment{
+			fd:        fd,
+			offset:    offset,
+			remaining: length,
+		})
+		conn.startWrite(idle)
+	})
+	return nil
+}
+
+// the head of the output queue is a file segment, at most limit bytes are sent,
+// returns false if nothing more can be written, like writeOnce
+func (conn *tcpConnection) sendFileOnce(limit int) bool {
+	seg := conn.outputQueue[0]
+	chunk := seg.remaining
+	if chunk > maxSendFileChunk {
+		chunk = maxSendFileChunk
+	}
+	if chunk > int64(limit) {
+		chunk = int64(limit)
+	}
+
+	n, err := syscall.Sendfile(conn.socketChannel.GetFD(), seg.fd, &seg.offset, int(chunk))
+	if err != nil {
+		if err == syscall.EINTR {
+			return true
+		}
+		if err == syscall.EAGAIN {
+			return false
+		}
+		conn.lastError = err
+		if err == syscall.ECONNRESET {
+			conn.handleClose(DisconnectReset)
+		} else {
+			conn.handleClose(DisconnectWriteError)
+		}
+		return false
+	}
+
+	// the file is truncated, the peer would wait for the missing bytes forever
+	if n == 0 {
+		conn.lastError = io.ErrUnexpectedEOF
+		conn.handleClose(DisconnectWriteError)
+		return false
+	}
+
+	conn.countWritten(n)
+	conn.consumeWriteBudget(n)
+	seg.remaining -= int64(n)
+	if seg.remaining == 0 {
+		syscall.Close(seg.fd)
+		conn.popOutput()
+	}
+	return conn.afterWrite()
+}
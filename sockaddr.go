@@ -0,0 +1,66 @@
+package goreactor
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// returns the sockaddr for bind(2) and connect(2)
+func sockaddrFromAddrPort(addrPort netip.AddrPort) syscall.Sockaddr {
+	addr := addrPort.Addr()
+	if addr.Is4() {
+		return &syscall.SockaddrInet4{
+			Addr: addr.As4(),
+			Port: int(addrPort.Port()),
+		}
+	}
+
+	sa := &syscall.SockaddrInet6{
+		Addr: addr.As16(),
+		Port: int(addrPort.Port()),
+	}
+	if zone := addr.Zone(); zone != "" {
+		if ifi, err := net.InterfaceByName(zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return sa
+}
+
+// converts the sockaddr returned by accept(2), getsockname(2) or getpeername(2),
+// IPv4-mapped IPv6 addresses(::ffff:a.b.c.d) of dual-stack sockets are unmapped,
+// unix socket addresses have no AddrPort, the zero value is returned
+func addrPortFromSockaddr(sa syscall.Sockaddr) netip.AddrPort {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *syscall.SockaddrInet6:
+		addr := netip.AddrFrom16(sa.Addr)
+		if addr.Is4In6() {
+			return netip.AddrPortFrom(addr.Unmap(), uint16(sa.Port))
+		}
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr = addr.WithZone(ifi.Name)
+			}
+		}
+		return netip.AddrPortFrom(addr, uint16(sa.Port))
+	}
+
+	return netip.AddrPort{}
+}
+
+// returns the socket family for socket(2)
+func sockaddrFamily(sa syscall.Sockaddr) int {
+	switch sa.(type) {
+	case *syscall.SockaddrInet4:
+		return syscall.AF_INET
+	case *syscall.SockaddrInet6:
+		return syscall.AF_INET6
+	case *syscall.SockaddrUnix:
+		return syscall.AF_UNIX
+	}
+
+	panic("unsupported sockaddr")
+}
@@ -0,0 +1,162 @@
+package goreactor
+
+import (
+	"sync/atomic"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// a snapshot of the counters of a connection, see TCPConnection.Stats
+type ConnectionStats struct {
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// calls of the message callback
+	Messages uint64
+
+	// times a Send could not write all bytes to the socket and queued the rest,
+	// it happens when the kernel buffer is full because the peer is slow
+	WriteBlocked uint64
+
+	// when the connection is accepted or connected
+	CreatedAt time.Time
+	Age       time.Duration
+}
+
+// a snapshot of the connections of a loop, see TCPServer.Stats
+type LoopStats struct {
+	LoopID int
+
+	// connections alive in the loop
+	Active int64
+
+	// sums of the connections in the loop, closed connections included
+	BytesRead    uint64
+	BytesWritten uint64
+	Messages     uint64
+	WriteBlocked uint64
+}
+
+// a snapshot of a server, see TCPServer.Stats
+type ServerStats struct {
+	Accepted uint64
+	Active   int64
+
+	// connections closed in the accept path by the connection rate limit, they
+	// are not counted by Accepted, see TCPServer.SetConnectionRateLimit
+	Rejected uint64
+
+	// closed connections by reason, reasons without closed connections are omitted
+	Closed map[DisconnectReason]uint64
+
+	// sums of Loops
+	BytesRead    uint64
+	BytesWritten uint64
+	Messages     uint64
+	WriteBlocked uint64
+
+	// one for each working loop, or one for the base loop if there is no working loop
+	Loops []LoopStats
+}
+
+// counters are updated in loop goroutine and read by Stats in any goroutine
+type trafficCounters struct {
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	messages     atomic.Uint64
+	writeBlocked atomic.Uint64
+}
+
+// counters of all connections of a server in a loop
+type loopCounters struct {
+	trafficCounters
+	loop   eventloop.EventLoop
+	active atomic.Int64
+}
+
+func (conn *tcpConnection) Stats() ConnectionStats {
+	return ConnectionStats{
+		BytesRead:    conn.counters.bytesRead.Load(),
+		BytesWritten: conn.counters.bytesWritten.Load(),
+		Messages:     conn.counters.messages.Load(),
+		WriteBlocked: conn.counters.writeBlocked.Load(),
+		CreatedAt:    conn.createdAt,
+		Age:          time.Since(conn.createdAt),
+	}
+}
+
+func (conn *tcpConnection) countRead(n int) {
+	conn.counters.bytesRead.Add(uint64(n))
+	if conn.loopCounters != nil {
+		conn.loopCounters.bytesRead.Add(uint64(n))
+	}
+	if conn.idleTimeout != 0 {
+		conn.lastActive = time.Now()
+	}
+}
+
+func (conn *tcpConnection) countWritten(n int) {
+	conn.counters.bytesWritten.Add(uint64(n))
+	if conn.loopCounters != nil {
+		conn.loopCounters.bytesWritten.Add(uint64(n))
+	}
+	if conn.idleTimeout != 0 {
+		conn.lastActive = time.Now()
+	}
+}
+
+func (conn *tcpConnection) countMessage() {
+	conn.counters.messages.Add(1)
+	if conn.loopCounters != nil {
+		conn.loopCounters.messages.Add(1)
+	}
+}
+
+func (conn *tcpConnection) countWriteBlocked() {
+	conn.counters.writeBlocked.Add(1)
+	if conn.loopCounters != nil {
+		conn.loopCounters.writeBlocked.Add(1)
+	}
+}
+
+// returns the counters of loop, loop must be a loop of the server
+func (server *tcpServer) countersOf(loop eventloop.EventLoop) *loopCounters {
+	for _, c := range server.loopCounters {
+		if c.loop == loop {
+			return c
+		}
+	}
+	panic("unknown loop")
+}
+
+func (server *tcpServer) Stats() ServerStats {
+	stats := ServerStats{
+		Accepted: server.accepted.Load(),
+		Rejected: server.rejected.Load(),
+		Closed:   make(map[DisconnectReason]uint64),
+	}
+	for i := range server.closed {
+		if n := server.closed[i].Load(); n != 0 {
+			stats.Closed[DisconnectReason(i)] = n
+		}
+	}
+
+	for _, c := range server.loopCounters {
+		ls := LoopStats{
+			LoopID:       c.loop.GetID(),
+			Active:       c.active.Load(),
+			BytesRead:    c.bytesRead.Load(),
+			BytesWritten: c.bytesWritten.Load(),
+			Messages:     c.messages.Load(),
+			WriteBlocked: c.writeBlocked.Load(),
+		}
+		stats.Active += ls.Active
+		stats.BytesRead += ls.BytesRead
+		stats.BytesWritten += ls.BytesWritten
+		stats.Messages += ls.Messages
+		stats.WriteBlocked += ls.WriteBlocked
+		stats.Loops = append(stats.Loops, ls)
+	}
+	return stats
+}
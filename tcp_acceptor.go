@@ -2,6 +2,7 @@ package goreactor
 
 import (
 	"net/netip"
+	"os"
 	"syscall"
 
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
@@ -9,6 +10,8 @@ import (
 
 type newConnectionCallback func(socketfd int, peerAddr netip.AddrPort)
 
+const defaultMaxAcceptsPerWakeup = 64
+
 type tcpAcceptor struct {
 	// event loop
 	loop eventloop.EventLoop
@@ -16,8 +19,12 @@ type tcpAcceptor struct {
 	// be used to prevent double start
 	listening bool
 
-	// listen at
-	listenAddr netip.AddrPort
+	// listen at, can be IPv4, IPv6 or unix socket address. the port is the one
+	// chosen by the kernel after Listen if it is 0
+	listenAddr syscall.Sockaddr
+
+	// file mode of the unix socket file, 0 means not to chmod
+	unixPerm os.FileMode
 
 	// listen socket fd channel
 	socketChannel eventloop.Channel
@@ -25,6 +32,18 @@ type tcpAcceptor struct {
 	// new connection call back
 	newConnectionCallback newConnectionCallback
 
+	// accept errors are reported here instead of crashing the process
+	acceptErrorCallback AcceptErrorCallbackFunc
+
+	// accept at most maxAcceptsPerWakeup connections for each readable event, so
+	// that a connection storm can not starve other channels of the loop
+	maxAcceptsPerWakeup int
+
+	// an fd of /dev/null reserved for EMFILE, when fds are exhausted, it is closed
+	// to accept and close the pending connection, then it is opened again.
+	// otherwise the listen socket keeps readable and the loop spins
+	idleFD int
+
 	// syscall.Listen param, see man 2 listen()
 	// The backlog argument defines the maximum length to which the queue of pending connections for sockfd may grow.  If a  connection  request  arrives  when  the
 	// queue  is full, the client may receive an error with an indication of ECONNREFUSED or, if the underlying protocol supports retransmission, the request may be
@@ -32,8 +51,14 @@ type tcpAcceptor struct {
 	listenBackup int
 }
 
-func newTCPAcceptor(loop eventloop.EventLoop, listenAddr netip.AddrPort, listenBackup int) *tcpAcceptor {
-	socketFD, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+func newTCPAcceptor(loop eventloop.EventLoop, listenAddr syscall.Sockaddr, listenBackup int) *tcpAcceptor {
+	family := sockaddrFamily(listenAddr)
+	socketFD, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	idleFD, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
 	if err != nil {
 		panic(err)
 	}
@@ -46,10 +71,17 @@ func newTCPAcceptor(loop eventloop.EventLoop, listenAddr netip.AddrPort, listenB
 		listening:             false,
 		socketChannel:         c,
 		newConnectionCallback: defaultNewConnectionCallback,
+		acceptErrorCallback:   defaultAcceptErrorCallback,
+		maxAcceptsPerWakeup:   defaultMaxAcceptsPerWakeup,
+		idleFD:                idleFD,
 		listenBackup:          listenBackup,
 	}
 	c.SetReadCallback(acc.HandleRead)
 
+	if family == syscall.AF_UNIX {
+		return &acc
+	}
+
 	// 15 means SO_REUSEPORT
 	err = syscall.SetsockoptInt(socketFD, syscall.SOL_SOCKET, 15, 1)
 	if err != nil {
@@ -64,14 +96,34 @@ func (ac *tcpAcceptor) Listen() error {
 		panic("already listening")
 	}
 
-	err := syscall.Bind(ac.socketChannel.GetFD(), &syscall.SockaddrInet4{
-		Addr: ac.listenAddr.Addr().As4(),
-		Port: int(ac.listenAddr.Port()),
-	})
+	unixAddr, isUnix := ac.listenAddr.(*syscall.SockaddrUnix)
+	if isUnix {
+		err := removeStaleUnixSocket(unixAddr.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := syscall.Bind(ac.socketChannel.GetFD(), ac.listenAddr)
 	if err != nil {
 		return err
 	}
 
+	if !isUnix {
+		sa, err := syscall.Getsockname(ac.socketChannel.GetFD())
+		if err != nil {
+			return err
+		}
+		ac.listenAddr = sa
+	}
+
+	if isUnix && ac.unixPerm != 0 && !isAbstractUnixSocket(unixAddr.Name) {
+		err = os.Chmod(unixAddr.Name, ac.unixPerm)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = syscall.Listen(ac.socketChannel.GetFD(), ac.listenBackup)
 	if err != nil {
 		return err
@@ -83,17 +135,108 @@ func (ac *tcpAcceptor) Listen() error {
 	return nil
 }
 
+// stop listening and close the listen socket, the unix socket file is removed.
+// must be called in loop goroutine
+func (ac *tcpAcceptor) close() {
+	if !ac.listening {
+		return
+	}
+
+	ac.listening = false
+	ac.loop.RemoveChannelInLoopGoroutine(ac.socketChannel)
+	syscall.Close(ac.socketChannel.GetFD())
+	if ac.idleFD >= 0 {
+		syscall.Close(ac.idleFD)
+		ac.idleFD = -1
+	}
+
+	if unixAddr, ok := ac.listenAddr.(*syscall.SockaddrUnix); ok && !isAbstractUnixSocket(unixAddr.Name) {
+		os.Remove(unixAddr.Name)
+	}
+}
+
 func (ac *tcpAcceptor) HandleRead() {
-	nfd, addr, err := syscall.Accept(ac.socketChannel.GetFD())
+	for i := 0; i < ac.maxAcceptsPerWakeup && ac.listening; i++ {
+		nfd, addr, err := syscall.Accept4(ac.socketChannel.GetFD(), syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err == nil {
+			if ac.newConnectionCallback != nil {
+				ac.newConnectionCallback(nfd, addrPortFromSockaddr(addr))
+			}
+			continue
+		}
+
+		switch err {
+		case syscall.EAGAIN:
+			// no more pending connections
+			return
+		case syscall.EINTR:
+			continue
+		case syscall.ECONNABORTED, syscall.EPROTO, syscall.EPERM:
+			// the pending connection is broken or refused by firewall rules, skip it
+			ac.acceptErrorCallback(err)
+			continue
+		case syscall.EMFILE, syscall.ENFILE:
+			ac.acceptErrorCallback(err)
+			ac.dropPendingConnection()
+			return
+		default:
+			// ENOBUFS, ENOMEM... try again at next wakeup
+			ac.acceptErrorCallback(err)
+			return
+		}
+	}
+}
+
+// accept and close a pending connection with the reserved idle fd, the peer
+// sees the connection closed instead of hanging in the accept queue
+func (ac *tcpAcceptor) dropPendingConnection() {
+	if ac.idleFD < 0 {
+		return
+	}
+
+	syscall.Close(ac.idleFD)
+	nfd, _, err := syscall.Accept4(ac.socketChannel.GetFD(), syscall.SOCK_CLOEXEC)
+	if err == nil {
+		syscall.Close(nfd)
+	}
+
+	ac.idleFD, err = syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
 	if err != nil {
-		panic(err)
+		ac.idleFD = -1
+		ac.acceptErrorCallback(err)
+	}
+}
+
+func (ac *tcpAcceptor) SetAcceptErrorCallback(cb AcceptErrorCallbackFunc) {
+	ac.acceptErrorCallback = cb
+}
+
+// n must be positive
+func (ac *tcpAcceptor) SetMaxAcceptsPerWakeup(n int) {
+	if n <= 0 {
+		panic(n)
+	}
+
+	ac.maxAcceptsPerWakeup = n
+}
+
+// only be used for IPv6 listen address, must be called before Listen. if v6only
+// is false, the socket also accepts IPv4 connections(dual-stack), the default
+// is decided by /proc/sys/net/ipv6/bindv6only, which is usually 0
+func (ac *tcpAcceptor) SetIPv6Only(v6only bool) error {
+	if ac.listening {
+		panic("already listening")
+	}
+
+	if sockaddrFamily(ac.listenAddr) != syscall.AF_INET6 {
+		return syscall.EAFNOSUPPORT
 	}
 
-	if ac.newConnectionCallback != nil {
-		sockaddr := addr.(*syscall.SockaddrInet4)
-		addrPort := netip.AddrPortFrom(netip.AddrFrom4(sockaddr.Addr), uint16(sockaddr.Port))
-		ac.newConnectionCallback(nfd, addrPort)
+	val := 0
+	if v6only {
+		val = 1
 	}
+	return syscall.SetsockoptInt(ac.socketChannel.GetFD(), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, val)
 }
 
 func (ac *tcpAcceptor) SetNewConnectionCallback(cb newConnectionCallback) {
@@ -0,0 +1,199 @@
+package goreactor
+
+import (
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+const (
+	defaultRetryInitialDelay = time.Millisecond * 500
+	defaultRetryMaxDelay     = time.Second * 30
+)
+
+// TCPClientOptions controls how a TCPClient connects, the zero value connects
+// only once and does not reconnect
+type TCPClientOptions struct {
+	// retry when connecting fails
+	Retry bool
+
+	// delay before the first retry, doubled after each retry, 500ms if it is 0
+	RetryInitialDelay time.Duration
+
+	// upper bound of the retry delay, 30s if it is 0
+	RetryMaxDelay time.Duration
+
+	// give up after MaxRetries retries, 0 means infinite
+	MaxRetries int
+
+	// connect again after RetryInitialDelay when an established connection is
+	// disconnected
+	ReconnectOnDisconnect bool
+}
+
+type TCPClient interface {
+	SetConnectionCallback(f ConnectedCallbackFunc)
+	SetMessageCallback(f MessageCallbackFunc)
+	SetDisConnectedCallback(f DisConnectedCallbackFunc)
+	SetConnectFailedCallback(f ConnectFailedCallbackFunc)
+
+	// start connecting, can be called from any goroutine
+	Connect()
+
+	// shutdown write of the current connection and do not reconnect
+	Disconnect()
+
+	// stop connecting and retrying, the established connection is not affected
+	Stop()
+
+	// returns nil if there is no established connection
+	GetConnection() TCPConnection
+	GetEventLoop() eventloop.EventLoop
+}
+
+type tcpClient struct {
+	loop eventloop.EventLoop
+
+	connector *tcpConnector
+
+	reconnect bool
+
+	// only be accessed in loop goroutine
+	connect bool
+
+	// mu protects conn, GetConnection may be called from any goroutine
+	mu   sync.Mutex
+	conn *tcpConnection
+
+	connectedCallback     ConnectedCallbackFunc
+	msgCallback           MessageCallbackFunc
+	disconnectedCallback  DisConnectedCallbackFunc
+	connectFailedCallback ConnectFailedCallbackFunc
+}
+
+func (client *tcpClient) SetConnectionCallback(f ConnectedCallbackFunc) {
+	client.connectedCallback = f
+}
+
+func (client *tcpClient) SetMessageCallback(f MessageCallbackFunc) {
+	client.msgCallback = f
+}
+
+func (client *tcpClient) SetDisConnectedCallback(f DisConnectedCallbackFunc) {
+	client.disconnectedCallback = f
+}
+
+func (client *tcpClient) SetConnectFailedCallback(f ConnectFailedCallbackFunc) {
+	client.connectFailedCallback = f
+}
+
+func (client *tcpClient) Connect() {
+	client.loop.RunInLoop(func() {
+		client.connect = true
+		client.connector.start()
+	})
+}
+
+func (client *tcpClient) Disconnect() {
+	client.loop.RunInLoop(func() {
+		client.connect = false
+		client.connector.stop()
+		if conn := client.GetConnection(); conn != nil {
+			conn.ShutdownWrite()
+		}
+	})
+}
+
+func (client *tcpClient) Stop() {
+	client.loop.RunInLoop(func() {
+		client.connect = false
+		client.connector.stop()
+	})
+}
+
+func (client *tcpClient) GetConnection() TCPConnection {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	// avoid returning a non-nil interface holding a nil pointer
+	if client.conn == nil {
+		return nil
+	}
+	return client.conn
+}
+
+func (client *tcpClient) GetEventLoop() eventloop.EventLoop {
+	return client.loop
+}
+
+func (client *tcpClient) onNewConnection(socketfd int, peerAddr netip.AddrPort) {
+	conn := newConnection(client.loop, socketfd, peerAddr)
+	conn.setConnectedCallback(client.connectedCallback)
+	conn.setMessageCallback(client.msgCallback)
+	conn.SetDisConnectedCallback(client.disconnectedCallback)
+	conn.setCloseCallback(client.onConnectionClosed)
+
+	client.mu.Lock()
+	client.conn = conn
+	client.mu.Unlock()
+
+	conn.establishConn()
+}
+
+func (client *tcpClient) onConnectionClosed(conn *tcpConnection) {
+	client.mu.Lock()
+	current := client.conn == conn
+	if current {
+		client.conn = nil
+	}
+	client.mu.Unlock()
+
+	// a connection replaced by the one of a later Connect does not affect the
+	// connector
+	if !current {
+		return
+	}
+
+	client.connector.connectionClosed()
+	if client.reconnect && client.connect {
+		client.connector.reconnect()
+	}
+}
+
+func (client *tcpClient) onConnectFailed(err error) {
+	client.connectFailedCallback(client, err)
+}
+
+// connect to addrPort, for example "127.0.0.1:8000" or "[::1]:8000", the connections are
+// handled on loop
+func NewTCPClient(loop eventloop.EventLoop, addrPort string, opts TCPClientOptions) TCPClient {
+	serverAddr, err := netip.ParseAddrPort(addrPort)
+	if err != nil {
+		panic(err)
+	}
+
+	return newClient(loop, sockaddrFromAddrPort(serverAddr), opts)
+}
+
+func newClient(loop eventloop.EventLoop, serverAddr syscall.Sockaddr, opts TCPClientOptions) *tcpClient {
+	if opts.MaxRetries < 0 {
+		panic(opts.MaxRetries)
+	}
+
+	client := &tcpClient{
+		loop:                  loop,
+		connector:             newTCPConnector(loop, serverAddr, opts),
+		reconnect:             opts.ReconnectOnDisconnect,
+		connectedCallback:     defaultConnectedCallback,
+		msgCallback:           defaultMessageCallback,
+		disconnectedCallback:  defaultDisConnectedCallback,
+		connectFailedCallback: defaultConnectFailedCallback,
+	}
+	client.connector.setNewConnectionCallback(client.onNewConnection)
+	client.connector.setErrorCallback(client.onConnectFailed)
+
+	return client
+}
@@ -0,0 +1,40 @@
+package goreactor
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// the server closes connections right after accepting them, the client waits
+// for the initial retry delay before each reconnect
+func TestReconnectIsDelayed(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	server := NewTCPServer(loop, "127.0.0.1:0", 0, RoundRobin())
+	var accepted atomic.Int32
+	server.SetConnectionCallback(func(c TCPConnection) {
+		accepted.Add(1)
+		c.ForceClose()
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go loop.Loop()
+	t.Cleanup(loop.Stop)
+
+	clientLoop := eventloop.NewEventLoop()
+	client := NewTCPClient(clientLoop, server.GetListenAddrPort().String(), TCPClientOptions{
+		RetryInitialDelay:     100 * time.Millisecond,
+		ReconnectOnDisconnect: true,
+	})
+	client.Connect()
+	go clientLoop.Loop()
+	t.Cleanup(clientLoop.Stop)
+
+	time.Sleep(550 * time.Millisecond)
+	if n := accepted.Load(); n < 3 || n > 7 {
+		t.Fatalf("%d connections in 550ms", n)
+	}
+}
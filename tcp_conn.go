@@ -1,8 +1,12 @@
 package goreactor
 
 import (
+	"math"
 	"net/netip"
+	"os"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	kvcontext "github.com/markity/go-reactor/pkg/context"
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
@@ -19,13 +23,131 @@ const (
 	Disconnected  tcpConnectionState = 4
 )
 
+// what Send does when the output buffer would exceed its limit
+type OutputOverflowPolicy int
+
+const (
+	// close the connection, the data in the output buffer is discarded
+	OutputOverflowClose OutputOverflowPolicy = 1
+	// discard the bytes of this Send, the connection is kept
+	OutputOverflowReject OutputOverflowPolicy = 2
+)
+
+// why the connection is closed, see TCPConnection.GetDisconnectReason
+type DisconnectReason int
+
+const (
+	// the peer closed the connection gracefully, read(2) returns 0
+	DisconnectPeerClosed DisconnectReason = 1
+	// the peer sent RST, ECONNRESET
+	DisconnectReset DisconnectReason = 2
+	// closed by ForceClose
+	DisconnectForceClose DisconnectReason = 3
+	// no data is read or written in the idle timeout, see SetIdleTimeout
+	DisconnectIdleTimeout DisconnectReason = 4
+	// write(2) fails with an error other than ECONNRESET
+	DisconnectWriteError DisconnectReason = 5
+	// the output buffer exceeds its limit, see SetMaxOutputBufferSize
+	DisconnectBufferOverflow DisconnectReason = 6
+	// force closed by TCPServer.Shutdown
+	DisconnectServerShutdown DisconnectReason = 7
+	// read(2) fails or the socket reports a pending error, for example ETIMEDOUT
+	// of tcp keepalive
+	DisconnectSocketError DisconnectReason = 8
+	// the frame decoder fails, see NewFrameMessageCallback
+	DisconnectFrameError DisconnectReason = 9
+	// the PROXY protocol header is invalid or not received in time, see
+	// TCPServer.SetProxyProtocol
+	DisconnectProxyHeaderError DisconnectReason = 10
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectPeerClosed:
+		return "PeerClosed"
+	case DisconnectReset:
+		return "Reset"
+	case DisconnectForceClose:
+		return "ForceClose"
+	case DisconnectIdleTimeout:
+		return "IdleTimeout"
+	case DisconnectWriteError:
+		return "WriteError"
+	case DisconnectBufferOverflow:
+		return "BufferOverflow"
+	case DisconnectServerShutdown:
+		return "ServerShutdown"
+	case DisconnectSocketError:
+		return "SocketError"
+	case DisconnectFrameError:
+		return "FrameError"
+	case DisconnectProxyHeaderError:
+		return "ProxyHeaderError"
+	default:
+		return "None"
+	}
+}
+
 type TCPConnection interface {
 	SetDisConnectedCallback(f DisConnectedCallbackFunc)
+
+	// called once when the output buffer grows above the high water level, it is
+	// called again only after the buffer drains to the low water level
 	SetHighWaterCallback(f HighWaterCallbackFunc)
+
+	// called once when the output buffer drains to the low water level after the
+	// high water callback is called
+	SetLowWaterCallback(f LowWaterCallbackFunc)
+
+	// 0 means infinite, the high water callback is never called
+	SetHighWaterLevel(i int)
+
+	// must be less than the high water level, the default is 0
+	SetLowWaterLevel(i int)
+
+	// if f is not nil, the connection works in half-close mode: when the peer
+	// shutdown its write side, the connection is not closed, f is called instead,
+	// and Send still works. the connection is closed after ShutdownWrite flushes
+	// the output buffer. must be called in loop goroutine, for example in the
+	// connected callback
+	SetPeerClosedWriteCallback(f PeerClosedWriteCallbackFunc)
+
+	// hard limit of the output buffer, 0 means infinite, a Send which makes the
+	// output buffer exceed n is handled by policy, it prevents a slow consumer
+	// from exhausting memory
+	SetMaxOutputBufferSize(n int, policy OutputOverflowPolicy)
+
 	SetWriteCompleteCallback(f WriteCompleteCallbackFunc)
 	Send(bs []byte)
+
+	// like Send, but the slices are queued without copying and flushed by one
+	// writev(2), the caller must not modify them after the call
+	SendV(bss [][]byte)
+
+	// like Send, but bs is queued without copying, the caller must not modify
+	// it after the call
+	SendOwned(bs []byte)
+
+	// queue length bytes of f from offset, they are sent by sendfile(2) in order
+	// with the bytes of Send before and after it, the write complete callback is
+	// called after they are sent. f is duplicated, it can be closed after the call.
+	// the bytes are not counted by water levels and the output buffer limit
+	SendFile(f *os.File, offset int64, length int64) error
+
 	ShutdownWrite()
+
+	// the source address of the PROXY protocol header if it is received
 	GetRemoteAddrPort() netip.AddrPort
+
+	// the destination address of the PROXY protocol header if it is received,
+	// or the address of the socket, see getsockname(2). the zero value for unix
+	// sockets
+	GetLocalAddrPort() netip.AddrPort
+
+	// nil if the server does not use PROXY protocol, see TCPServer.SetProxyProtocol.
+	// it is set before the connected callback is called
+	GetProxyHeader() *ProxyHeader
+
 	ForceClose()
 	SetKeepAlive(b bool)
 	SetNoDelay(b bool)
@@ -36,6 +158,52 @@ type TCPConnection interface {
 	MustGetContext(key string) interface{}
 	GetFD() int
 	IsConnected() bool
+
+	// only be used for unix domain socket connections, see SO_PEERCRED in unix(7)
+	GetPeerCredentials() (*syscall.Ucred, error)
+
+	// stop reading from the socket, the peer is slowed down by tcp flow control
+	// when the kernel buffer is full. data already in the input buffer is kept.
+	// calls are counted, reading is resumed after every PauseRead is released by
+	// a ResumeRead, so pausers such as SetBackpressure do not resume each other
+	PauseRead()
+	ResumeRead()
+	IsReadPaused() bool
+
+	// pause reading from this connection when the output buffer of downstream
+	// grows above highWater, and resume reading when it drains to lowWater, it
+	// is useful for proxies. downstream can be a connection of go-reactor or a
+	// wrapper of it like reactortls.TLSConnection, if highWater is 0, the
+	// backpressure from downstream is removed
+	SetBackpressure(downstream TCPConnection, highWater int, lowWater int)
+
+	// counters of the connection, it can be called in any goroutine
+	Stats() ConnectionStats
+
+	// close the connection with DisconnectIdleTimeout if nothing is read from or
+	// written to the socket in d, 0 disables it
+	SetIdleTimeout(d time.Duration)
+
+	// token bucket limits of the socket throughput, bursts of burst bytes are
+	// allowed. reading stops until the bucket refills, and queued bytes wait for
+	// it, see TCPServer.SetPerIPReadLimit for limits shared by connections of the
+	// same remote IP. bytesPerSecond 0 removes the limit
+	SetReadLimit(bytesPerSecond int, burst int)
+	SetWriteLimit(bytesPerSecond int, burst int)
+
+	// why the connection is closed, 0 if it is not closed. must be called in loop
+	// goroutine, for example in the disconnected callback
+	GetDisconnectReason() DisconnectReason
+
+	// the errno which closes the connection, or the error of the frame decoder
+	// for DisconnectFrameError. nil if it is closed without error, for example
+	// DisconnectPeerClosed or DisconnectForceClose. must be called in loop
+	// goroutine, for example in the disconnected callback
+	GetLastError() error
+
+	// the connection of go-reactor, wrappers like reactortls.TLSConnection embed
+	// TCPConnection, so it returns the wrapped connection for them
+	underlying() *tcpConnection
 }
 
 // 能被多个协程share
@@ -50,15 +218,105 @@ type tcpConnection struct {
 	disconnectedCallback  DisConnectedCallbackFunc
 	messageCallback       MessageCallbackFunc
 	highWaterCallback     HighWaterCallbackFunc
+	lowWaterCallback      LowWaterCallbackFunc
 	writeCompleteCallback WriteCompleteCallbackFunc
 
+	// internal callback, be used by the owner(for example tcpClient) to know
+	// the connection is closed, called after disconnectedCallback
+	closeCallback func(*tcpConnection)
+
+	// internal callback set by TCPServer.Shutdown, called once when the output is
+	// flushed and the write side is shut down, or the connection is closed
+	drainedCallback func()
+
+	// SHUT_WR is done
+	writeShut bool
+
 	// 0 means infinite
-	hignWaterLevel int
+	highWaterLevel int
+	lowWaterLevel  int
 
-	remoteAddrPort netip.AddrPort
+	// set when the high water callback is called, cleared when the output buffer
+	// drains to lowWaterLevel, makes the callbacks edge-triggered
+	aboveHighWater bool
+
+	// 0 means infinite
+	maxOutputBufferSize int
+	overflowPolicy      OutputOverflowPolicy
 
-	outputBuffer buffer.Buffer
-	inputBuffer  buffer.Buffer
+	// it may be read in any goroutine while the PROXY protocol header replaces
+	// it in loop goroutine
+	remoteAddrPort atomic.Pointer[netip.AddrPort]
+
+	// got by getsockname(2) when it is used
+	localAddrPort    netip.AddrPort
+	localAddrFetched bool
+
+	// the connected callback is delayed until the PROXY protocol header is
+	// received, 0 means PROXY protocol is not used, see proxy_protocol.go
+	proxyHeaderTimeout  time.Duration
+	awaitingProxyHeader bool
+	proxyTimerID        int
+	proxyHeader         *ProxyHeader
+
+	inputBuffer buffer.Buffer
+
+	// bytes and file segments waiting to be sent in order, see output_queue.go
+	outputQueue []*outputSegment
+
+	// a drained buffer of the output queue, reused by the next Send
+	spareBuffer buffer.Buffer
+
+	// be used by writev(2), kept to avoid allocating for every write
+	iovecs []syscall.Iovec
+
+	// PauseRead calls not released by ResumeRead yet, the channel does not have
+	// ReadableEvent when it is not 0
+	readPauses int
+
+	// half-close mode, see SetPeerClosedWriteCallback
+	peerClosedWriteCallback PeerClosedWriteCallbackFunc
+
+	// set when the peer shutdown its write side in half-close mode, reading is
+	// disabled after that
+	peerClosedWrite bool
+
+	// the errno which closes the connection
+	lastError error
+
+	// set by handleClose
+	disconnectReason DisconnectReason
+
+	// 0 means no idle timeout, see SetIdleTimeout
+	idleTimeout time.Duration
+	idleTimerID int
+
+	// the last time data is read or written, only be updated when idleTimeout
+	// is set
+	lastActive time.Time
+
+	// connections which read data and send to this connection, they are paused
+	// when the output queue is too large, see SetBackpressure
+	backpressureWatchers []*backpressureWatcher
+
+	counters  trafficCounters
+	createdAt time.Time
+
+	// the counters of the loop, nil if the connection is not owned by a server
+	loopCounters *loopCounters
+
+	// token buckets of the connection and of its remote IP, nil means no limit,
+	// see rate_limit.go
+	readLimit  *tokenBucket
+	writeLimit *tokenBucket
+	ipLimiter  *ipLimiter
+	ipBuckets  *ipBuckets
+
+	// set when a budget is exhausted, until the timer fires
+	readThrottled  bool
+	readTimerID    int
+	writeThrottled bool
+	writeTimerID   int
 
 	ctx kvcontext.KVContext
 }
@@ -71,13 +329,46 @@ func (tc *tcpConnection) setMessageCallback(f MessageCallbackFunc) {
 	tc.messageCallback = f
 }
 
+func (tc *tcpConnection) setCloseCallback(f func(*tcpConnection)) {
+	tc.closeCallback = f
+}
+
 func (tc *tcpConnection) SetHighWaterCallback(f HighWaterCallbackFunc) {
 	tc.highWaterCallback = f
 }
 
+func (tc *tcpConnection) SetLowWaterCallback(f LowWaterCallbackFunc) {
+	tc.lowWaterCallback = f
+}
+
 // 0 means infinite
 func (tc *tcpConnection) SetHighWaterLevel(i int) {
-	tc.hignWaterLevel = i
+	if i < 0 {
+		panic(i)
+	}
+	tc.highWaterLevel = i
+}
+
+func (tc *tcpConnection) SetLowWaterLevel(i int) {
+	if i < 0 {
+		panic(i)
+	}
+	tc.lowWaterLevel = i
+}
+
+func (tc *tcpConnection) SetMaxOutputBufferSize(n int, policy OutputOverflowPolicy) {
+	if n < 0 {
+		panic(n)
+	}
+	if policy != OutputOverflowClose && policy != OutputOverflowReject {
+		panic(policy)
+	}
+	tc.maxOutputBufferSize = n
+	tc.overflowPolicy = policy
+}
+
+func (tc *tcpConnection) SetPeerClosedWriteCallback(f PeerClosedWriteCallbackFunc) {
+	tc.peerClosedWriteCallback = f
 }
 
 func (tc *tcpConnection) SetWriteCompleteCallback(f WriteCompleteCallbackFunc) {
@@ -94,59 +385,264 @@ func newConnection(loop eventloop.EventLoop, sockFD int, remoteAddrPort netip.Ad
 		state:                 Connecting,
 		loop:                  loop,
 		socketChannel:         channel,
-		outputBuffer:          buffer.NewBuffer(),
 		inputBuffer:           buffer.NewBuffer(),
-		remoteAddrPort:        remoteAddrPort,
 		highWaterCallback:     defaultHighWaterMarkCallback,
+		lowWaterCallback:      defaultLowWaterMarkCallback,
 		writeCompleteCallback: defaultWriteCompleteCallback,
 		disconnectedCallback:  defaultDisConnectedCallback,
 		ctx:                   kvcontext.NewContext(),
+		createdAt:             time.Now(),
 	}
+	c.remoteAddrPort.Store(&remoteAddrPort)
 	channel.SetReadCallback(c.handleRead)
 	channel.SetWriteCallback(c.handleWrite)
+	channel.SetCloseCallback(c.handleHup)
+	channel.SetErrorCallback(c.handleError)
 	channel.SetEvent(eventloop.ReadableEvent | eventloop.WritableEvent)
+	channel.EnableReadHup()
 
 	return c
 }
 
 func (conn *tcpConnection) Send(bs []byte) {
 	conn.loop.RunInLoop(func() {
-		if conn.state == Connected {
-			conn.outputBuffer.Append(bs)
-			if conn.hignWaterLevel != 0 && conn.outputBuffer.ReadableBytes() > conn.hignWaterLevel {
-				conn.highWaterCallback(conn, conn.outputBuffer.ReadableBytes())
-			}
-			if !conn.socketChannel.IsWriting() {
-				conn.socketChannel.EnableWrite()
-				conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+		conn.sendInLoop([][]byte{bs}, false)
+	})
+}
+
+func (conn *tcpConnection) SendV(bss [][]byte) {
+	conn.loop.RunInLoop(func() {
+		conn.sendInLoop(bss, true)
+	})
+}
+
+func (conn *tcpConnection) SendOwned(bs []byte) {
+	conn.loop.RunInLoop(func() {
+		conn.sendInLoop([][]byte{bs}, true)
+	})
+}
+
+// if nothing is queued, the slices are written directly, it saves the epoll_ctl
+// calls and the wakeup for writable event when the kernel buffer has room. the
+// rest is queued, copied if owned is false
+func (conn *tcpConnection) sendInLoop(bss [][]byte, owned bool) {
+	if conn.state != Connected {
+		return
+	}
+
+	sz := 0
+	for _, bs := range bss {
+		sz += len(bs)
+	}
+	if conn.maxOutputBufferSize != 0 && conn.bufferedBytes()+sz > conn.maxOutputBufferSize {
+		if conn.overflowPolicy == OutputOverflowClose {
+			conn.handleClose(DisconnectBufferOverflow)
+		}
+		return
+	}
+
+	idle := !conn.hasPendingOutput()
+	written := 0
+	blocked := false
+	if idle {
+		budget := conn.writeBudget()
+		n, err := 0, error(nil)
+		if budget == 0 {
+			err = syscall.EAGAIN
+		} else {
+			n, err = conn.writeSlices(bss, budget)
+		}
+		if err != nil {
+			if err == syscall.EAGAIN {
+				blocked = true
+			} else if err != syscall.EINTR {
+				conn.lastError = err
+				if err == syscall.ECONNRESET {
+					conn.handleClose(DisconnectReset)
+				} else {
+					conn.handleClose(DisconnectWriteError)
+				}
+				return
 			}
+		} else if n > 0 {
+			written = n
+			conn.countWritten(n)
+			conn.consumeWriteBudget(n)
 		}
-	})
+
+		// queued, the callback may call Send again
+		if written == sz {
+			conn.loop.QueueInLoop(func() {
+				if conn.state != Disconnected && !conn.hasPendingOutput() {
+					conn.writeCompleteCallback(conn)
+				}
+			})
+			return
+		}
+	}
+
+	if idle {
+		conn.countWriteBlocked()
+	}
+	for _, bs := range bss {
+		if written >= len(bs) {
+			written -= len(bs)
+			continue
+		}
+		bs = bs[written:]
+		written = 0
+		if owned {
+			conn.queueOwned(bs)
+		} else {
+			conn.tailBuffer().Append(bs)
+		}
+	}
+
+	if conn.highWaterLevel != 0 && !conn.aboveHighWater && conn.bufferedBytes() > conn.highWaterLevel {
+		conn.aboveHighWater = true
+		conn.highWaterCallback(conn, conn.bufferedBytes())
+	}
+	conn.checkBackpressure()
+
+	// in edge-triggered mode, the writable event comes only after EAGAIN
+	conn.startWrite(idle && !blocked)
+}
+
+// be called after data is queued, writeNow is true if nothing was queued before
+// and the kernel buffer may have room
+func (conn *tcpConnection) startWrite(writeNow bool) {
+	// the timer of the write limit starts writing
+	if conn.writeThrottled {
+		return
+	}
+
+	if conn.socketChannel.IsEdgeTriggered() {
+		// the writable event is reported only once, write now if nothing is
+		// pending, otherwise the next writable event flushes the buffer
+		if writeNow {
+			conn.handleWrite()
+		}
+	} else if !conn.socketChannel.IsWriting() {
+		conn.socketChannel.EnableWrite()
+		conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+	}
 }
 
 func (conn *tcpConnection) ShutdownWrite() {
 	conn.loop.RunInLoop(func() {
 		if conn.state == Connected {
 			conn.state = Disconnecting
-			if !conn.socketChannel.IsWriting() {
-				syscall.Shutdown(conn.socketChannel.GetFD(), syscall.SHUT_WR)
+			if !conn.isWritePending() {
+				conn.shutdownWriteInLoop()
 			}
 		}
 	})
 }
 
 func (conn *tcpConnection) GetRemoteAddrPort() netip.AddrPort {
-	return conn.remoteAddrPort
+	return *conn.remoteAddrPort.Load()
+}
+
+func (conn *tcpConnection) GetLocalAddrPort() netip.AddrPort {
+	c := make(chan netip.AddrPort, 1)
+	conn.loop.RunInLoop(func() {
+		if conn.proxyHeader != nil && conn.proxyHeader.Destination.IsValid() {
+			c <- conn.proxyHeader.Destination
+			return
+		}
+
+		// the fd may be reused after the connection is closed
+		if !conn.localAddrFetched && conn.state != Disconnected {
+			conn.localAddrFetched = true
+			if sa, err := syscall.Getsockname(conn.socketChannel.GetFD()); err == nil {
+				conn.localAddrPort = addrPortFromSockaddr(sa)
+			}
+		}
+		c <- conn.localAddrPort
+	})
+	return <-c
 }
 
 func (conn *tcpConnection) ForceClose() {
+	conn.forceCloseWithReason(DisconnectForceClose)
+}
+
+func (conn *tcpConnection) forceCloseWithReason(reason DisconnectReason) {
 	conn.loop.RunInLoop(func() {
 		if conn.state == Disconnecting || conn.state == Connected {
-			conn.handleClose()
+			conn.handleClose(reason)
 		}
 	})
 }
 
+// closes the connection when Shutdown times out. close(2) sends RST if unread
+// input is left in the kernel buffer, and the peer may drop the responses it has
+// not read yet, so the input is discarded first
+func (conn *tcpConnection) closeForShutdown() {
+	conn.loop.RunInLoop(func() {
+		if conn.state != Disconnecting && conn.state != Connected {
+			return
+		}
+
+		extrabuf := conn.loop.GetExtraData()
+		for {
+			n, err := syscall.Read(conn.socketChannel.GetFD(), extrabuf)
+			if n <= 0 && err != syscall.EINTR {
+				break
+			}
+		}
+		conn.handleClose(DisconnectServerShutdown)
+	})
+}
+
+func (conn *tcpConnection) SetIdleTimeout(d time.Duration) {
+	if d < 0 {
+		panic(d)
+	}
+
+	conn.loop.RunInLoop(func() {
+		conn.idleTimeout = d
+		conn.lastActive = time.Now()
+		if conn.state == Connected || conn.state == Disconnecting {
+			conn.resetIdleTimer(d)
+		}
+	})
+}
+
+// cancel the idle timer, and setup a new one which fires after d if d is not 0
+func (conn *tcpConnection) resetIdleTimer(d time.Duration) {
+	if conn.idleTimerID != 0 {
+		conn.loop.CancelTimer(conn.idleTimerID)
+		conn.idleTimerID = 0
+	}
+	if d != 0 {
+		conn.idleTimerID = conn.loop.RunAt(time.Now().Add(d), 0, conn.handleIdleTimer)
+	}
+}
+
+func (conn *tcpConnection) handleIdleTimer(timerID int) {
+	conn.idleTimerID = 0
+	if conn.state != Connected && conn.state != Disconnecting {
+		return
+	}
+
+	// the connection is active after the timer is setup, wait for the rest time
+	idle := time.Since(conn.lastActive)
+	if idle < conn.idleTimeout {
+		conn.resetIdleTimer(conn.idleTimeout - idle)
+		return
+	}
+	conn.handleClose(DisconnectIdleTimeout)
+}
+
+func (conn *tcpConnection) GetDisconnectReason() DisconnectReason {
+	return conn.disconnectReason
+}
+
+func (conn *tcpConnection) GetLastError() error {
+	return conn.lastError
+}
+
 func (conn *tcpConnection) SetKeepAlive(b bool) {
 	conn.loop.RunInLoop(func() {
 		val := 0
@@ -167,40 +663,297 @@ func (conn *tcpConnection) SetNoDelay(b bool) {
 	})
 }
 
+func (conn *tcpConnection) PauseRead() {
+	conn.loop.RunInLoop(func() {
+		conn.readPauses++
+		if conn.readPauses != 1 {
+			return
+		}
+
+		if conn.state == Connected || conn.state == Disconnecting {
+			if conn.socketChannel.DisableRead() {
+				conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+			}
+		}
+	})
+}
+
+func (conn *tcpConnection) ResumeRead() {
+	conn.loop.RunInLoop(func() {
+		if conn.readPauses == 0 {
+			return
+		}
+
+		conn.readPauses--
+		if conn.readPauses != 0 {
+			return
+		}
+
+		if (conn.state == Connected || conn.state == Disconnecting) && !conn.peerClosedWrite &&
+			!conn.readThrottled {
+			if conn.socketChannel.EnableRead() {
+				conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+			}
+		}
+	})
+}
+
+func (conn *tcpConnection) IsReadPaused() bool {
+	c := make(chan bool, 1)
+	conn.loop.RunInLoop(func() {
+		c <- conn.readPauses != 0
+	})
+	return <-c
+}
+
 func (conn *tcpConnection) handleRead() {
-	n := conn.inputBuffer.ReadFD(conn.socketChannel.GetFD(), conn.GetEventLoop().GetExtraData())
+	// in edge-triggered mode, read until EAGAIN, the readable event is not
+	// reported again for the data left in the kernel buffer
+	for {
+		if !conn.readOnce() || !conn.socketChannel.IsEdgeTriggered() {
+			return
+		}
+	}
+}
+
+// returns false if nothing more can be read
+func (conn *tcpConnection) readOnce() bool {
+	// the close callback or the error callback may close the connection in the
+	// same wakeup, the message callback may close it or pause reading
+	if conn.state == Disconnected || conn.peerClosedWrite || conn.readPauses != 0 || conn.readThrottled {
+		return false
+	}
+	budget := conn.readBudget()
+	if budget == 0 {
+		return false
+	}
+
+	var n int
+	var err error
+	if budget == math.MaxInt {
+		n, err = conn.inputBuffer.ReadFD(conn.socketChannel.GetFD(), conn.GetEventLoop().GetExtraData())
+	} else {
+		n, err = conn.readLimited(budget)
+	}
+	if err != nil {
+		if err == syscall.EINTR {
+			return true
+		}
+		if err == syscall.EAGAIN {
+			return false
+		}
+		conn.lastError = err
+		if err == syscall.ECONNRESET {
+			conn.handleClose(DisconnectReset)
+		} else {
+			conn.handleClose(DisconnectSocketError)
+		}
+		return false
+	}
+
 	if n > 0 {
-		conn.messageCallback(conn, conn.inputBuffer)
-	} else if n <= 0 {
-		// n为0意味对面已经close write或close total了, 此时直接关闭连接
-		conn.handleClose()
+		conn.countRead(n)
+		conn.consumeReadBudget(n)
+		if conn.awaitingProxyHeader {
+			conn.handleProxyHeader()
+		} else {
+			conn.countMessage()
+			conn.messageCallback(conn, conn.inputBuffer)
+		}
+		return true
 	}
+
+	if conn.peerClosedWriteCallback != nil {
+		conn.handlePeerClosedWrite()
+		return false
+	}
+	// n为0意味对面已经close write或close total了, 此时直接关闭连接
+	conn.handleClose(DisconnectPeerClosed)
+	return false
 }
 
-func (conn *tcpConnection) handleWrite() {
-	n, _ := syscall.Write(conn.socketChannel.GetFD(), conn.outputBuffer.Peek()[:conn.outputBuffer.ReadableBytes()])
-	conn.outputBuffer.Retrieve(n)
-	if conn.outputBuffer.ReadableBytes() == 0 {
-		conn.socketChannel.DisableWrite()
+// true if the output buffer is waiting for the socket to be writable
+func (conn *tcpConnection) isWritePending() bool {
+	// the write interest is dropped while the write limit is exhausted
+	if conn.socketChannel.IsEdgeTriggered() || conn.writeThrottled {
+		return conn.hasPendingOutput()
+	}
+	return conn.socketChannel.IsWriting()
+}
+
+// half-close mode, the peer sends FIN, but we may still send responses
+func (conn *tcpConnection) handlePeerClosedWrite() {
+	conn.peerClosedWrite = true
+	if conn.socketChannel.DisableRead() {
 		conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+	}
+
+	// we have shutdown write, both directions are closed now
+	if conn.state == Disconnecting && !conn.isWritePending() {
+		conn.handleClose(DisconnectPeerClosed)
+		return
+	}
+
+	conn.peerClosedWriteCallback(conn)
+}
+
+// the output buffer is flushed, send FIN to the peer
+func (conn *tcpConnection) shutdownWriteInLoop() {
+	syscall.Shutdown(conn.socketChannel.GetFD(), syscall.SHUT_WR)
+	conn.writeShut = true
+	conn.notifyDrained()
+	if conn.peerClosedWrite {
+		conn.handleClose(DisconnectPeerClosed)
+	}
+}
+
+// like ShutdownWrite, f is called in loop goroutine once the output is flushed
+// and the write side is shut down, or the connection is closed
+func (conn *tcpConnection) shutdownWriteAndNotify(f func()) {
+	conn.loop.RunInLoop(func() {
+		if conn.writeShut || (conn.state != Connected && conn.state != Disconnecting) {
+			f()
+			return
+		}
+
+		conn.drainedCallback = f
+		if conn.state == Connected {
+			conn.state = Disconnecting
+			if !conn.isWritePending() {
+				conn.shutdownWriteInLoop()
+			}
+		}
+	})
+}
+
+func (conn *tcpConnection) notifyDrained() {
+	if f := conn.drainedCallback; f != nil {
+		conn.drainedCallback = nil
+		f()
+	}
+}
+
+// HupEvent without ReadableEvent, both directions are closed
+func (conn *tcpConnection) handleHup() {
+	if conn.state == Connected || conn.state == Disconnecting {
+		conn.handleClose(DisconnectPeerClosed)
+	}
+}
+
+// ErrorEvent, for example the peer sends RST
+func (conn *tcpConnection) handleError() {
+	soErr, err := syscall.GetsockoptInt(conn.socketChannel.GetFD(), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && soErr != 0 {
+		conn.lastError = syscall.Errno(soErr)
+	}
+
+	if conn.state == Connected || conn.state == Disconnecting {
+		if conn.lastError == syscall.ECONNRESET {
+			conn.handleClose(DisconnectReset)
+		} else {
+			conn.handleClose(DisconnectSocketError)
+		}
+	}
+}
+
+func (conn *tcpConnection) handleWrite() {
+	// in edge-triggered mode, write until EAGAIN or the output buffer is empty,
+	// the writable event is reported again only after EAGAIN
+	for {
+		if !conn.writeOnce() || !conn.socketChannel.IsEdgeTriggered() {
+			return
+		}
+	}
+}
+
+// returns false if nothing more can be written
+func (conn *tcpConnection) writeOnce() bool {
+	// handleRead may close the connection in the same wakeup, the low water
+	// callback may close it too
+	if conn.state == Disconnected {
+		return false
+	}
+
+	// the writable event of edge-triggered channels is reported even if there is
+	// nothing to write, for example right after the channel is registered
+	if conn.socketChannel.IsEdgeTriggered() && !conn.hasPendingOutput() {
+		return false
+	}
+
+	budget := conn.writeBudget()
+	if budget == 0 {
+		return false
+	}
+
+	if conn.hasPendingOutput() && conn.outputQueue[0].isFile() {
+		return conn.sendFileOnce(budget)
+	}
+
+	n, err := conn.writeBuffered(budget)
+	if err != nil {
+		// the socket is non-blocking, EAGAIN means the kernel buffer is full
+		if err == syscall.EINTR {
+			return true
+		}
+		if err == syscall.EAGAIN {
+			return false
+		}
+		conn.lastError = err
+		if err == syscall.ECONNRESET {
+			conn.handleClose(DisconnectReset)
+		} else {
+			conn.handleClose(DisconnectWriteError)
+		}
+		return false
+	}
+	conn.countWritten(n)
+	conn.consumeWriteBudget(n)
+	conn.consumeOutput(n)
+	return conn.afterWrite()
+}
+
+// be called after some bytes are written, returns false if the output is flushed
+func (conn *tcpConnection) afterWrite() bool {
+	if conn.aboveHighWater && conn.bufferedBytes() <= conn.lowWaterLevel {
+		conn.aboveHighWater = false
+		conn.lowWaterCallback(conn, conn.bufferedBytes())
+	}
+	conn.checkBackpressure()
+	if !conn.hasPendingOutput() {
+		// the interest is never changed in edge-triggered mode, it saves epoll_ctl
+		if !conn.socketChannel.IsEdgeTriggered() && conn.socketChannel.DisableWrite() {
+			conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+		}
 
 		conn.writeCompleteCallback(conn)
 		if conn.state == Disconnecting {
-			syscall.Shutdown(conn.socketChannel.GetFD(), syscall.SHUT_WR)
+			conn.shutdownWriteInLoop()
 		}
+		return false
 	}
+	return true
 }
 
 // 比如对端直接close了socket, 那么此时就进入readhup状态了
-func (conn *tcpConnection) handleClose() {
+func (conn *tcpConnection) handleClose(reason DisconnectReason) {
 	if conn.state != Disconnecting && conn.state != Connected {
 		panic("checkme")
 	}
 
 	conn.state = Disconnected
+	conn.disconnectReason = reason
+	conn.resetIdleTimer(0)
+	conn.cancelProxyHeaderTimer()
+	conn.releaseRateLimits()
 	conn.loop.RemoveChannelInLoopGoroutine(conn.socketChannel)
 	syscall.Close(conn.socketChannel.GetFD())
+	conn.releaseOutput()
+	conn.releaseBackpressure()
+	conn.notifyDrained()
 	conn.disconnectedCallback(conn)
+	if conn.closeCallback != nil {
+		conn.closeCallback(conn)
+	}
 }
 
 func (conn *tcpConnection) establishConn() {
@@ -209,10 +962,26 @@ func (conn *tcpConnection) establishConn() {
 	}
 
 	conn.state = Connected
+	if conn.readPauses != 0 {
+		conn.socketChannel.DisableRead()
+	}
 	conn.loop.UpdateChannelInLoopGoroutine(conn.socketChannel)
+	if conn.idleTimeout != 0 {
+		conn.lastActive = time.Now()
+		conn.resetIdleTimer(conn.idleTimeout)
+	}
+	if conn.proxyHeaderTimeout != 0 {
+		conn.startProxyHeaderTimer()
+		return
+	}
+	conn.acquireIPBuckets()
 	conn.connectedCallback(conn)
 }
 
+func (conn *tcpConnection) underlying() *tcpConnection {
+	return conn
+}
+
 func (conn *tcpConnection) GetEventLoop() eventloop.EventLoop {
 	return conn.loop
 }
@@ -241,6 +1010,10 @@ func (conn *tcpConnection) GetFD() int {
 	return conn.socketChannel.GetFD()
 }
 
+func (conn *tcpConnection) GetPeerCredentials() (*syscall.Ucred, error) {
+	return syscall.GetsockoptUcred(conn.socketChannel.GetFD(), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+}
+
 func (conn *tcpConnection) IsConnected() bool {
 	c := make(chan bool, 1)
 	conn.loop.RunInLoop(func() {
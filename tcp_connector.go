@@ -0,0 +1,252 @@
+package goreactor
+
+import (
+	"syscall"
+	"time"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+type connectorState int
+
+const (
+	connectorDisconnected connectorState = 1
+	connectorConnecting   connectorState = 2
+	connectorConnected    connectorState = 3
+)
+
+// tcpConnector initiates a non-blocking connect(2) and retries with exponential
+// backoff, all methods must be called in the loop goroutine
+type tcpConnector struct {
+	// event loop
+	loop eventloop.EventLoop
+
+	// connect to, can be IPv4, IPv6 or unix socket address
+	serverAddr syscall.Sockaddr
+
+	// cleared by stop, prevents further connect attempts
+	connect bool
+
+	state connectorState
+
+	// channel of the connecting socket, nil if there is no pending connect
+	socketChannel eventloop.Channel
+
+	// retry settings, see TCPClientOptions
+	retry        bool
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	maxRetries   int
+
+	// delay of the next retry, doubled after each retry
+	retryDelay time.Duration
+
+	// how many retries have been done since the last successful connect
+	retries int
+
+	// 0 means no retry timer is pending
+	retryTimerID int
+
+	// called with the connected socket fd
+	newConnectionCallback newConnectionCallback
+
+	// called when connecting fails and no more retries will be done
+	errorCallback func(error)
+}
+
+func newTCPConnector(loop eventloop.EventLoop, serverAddr syscall.Sockaddr, opts TCPClientOptions) *tcpConnector {
+	if opts.RetryInitialDelay <= 0 {
+		opts.RetryInitialDelay = defaultRetryInitialDelay
+	}
+	if opts.RetryMaxDelay <= 0 {
+		opts.RetryMaxDelay = defaultRetryMaxDelay
+	}
+	if opts.RetryMaxDelay < opts.RetryInitialDelay {
+		opts.RetryMaxDelay = opts.RetryInitialDelay
+	}
+
+	return &tcpConnector{
+		loop:                  loop,
+		serverAddr:            serverAddr,
+		state:                 connectorDisconnected,
+		retry:                 opts.Retry,
+		initialDelay:          opts.RetryInitialDelay,
+		maxDelay:              opts.RetryMaxDelay,
+		maxRetries:            opts.MaxRetries,
+		retryDelay:            opts.RetryInitialDelay,
+		newConnectionCallback: defaultNewConnectionCallback,
+	}
+}
+
+func (cn *tcpConnector) setNewConnectionCallback(cb newConnectionCallback) {
+	cn.newConnectionCallback = cb
+}
+
+func (cn *tcpConnector) setErrorCallback(cb func(error)) {
+	cn.errorCallback = cb
+}
+
+func (cn *tcpConnector) start() {
+	cn.connect = true
+	if cn.state == connectorDisconnected && cn.retryTimerID == 0 {
+		cn.doConnect()
+	}
+}
+
+// be called when the connection made by the connector is closed, so start can
+// connect again
+func (cn *tcpConnector) connectionClosed() {
+	if cn.state == connectorConnected {
+		cn.state = connectorDisconnected
+	}
+}
+
+// connect again after the initial retry delay when the connection is closed, so
+// a server which closes connections right after accepting them is not flooded
+func (cn *tcpConnector) reconnect() {
+	cn.connect = true
+	if cn.state == connectorDisconnected && cn.retryTimerID == 0 {
+		cn.connectAfter(cn.initialDelay)
+	}
+}
+
+func (cn *tcpConnector) stop() {
+	cn.connect = false
+	if cn.retryTimerID != 0 {
+		cn.loop.CancelTimer(cn.retryTimerID)
+		cn.retryTimerID = 0
+	}
+	if cn.state == connectorConnecting {
+		syscall.Close(cn.removeChannel())
+	}
+	cn.state = connectorDisconnected
+}
+
+func (cn *tcpConnector) doConnect() {
+	family := sockaddrFamily(cn.serverAddr)
+	socketFD, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		switch err {
+		case syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM:
+			// some fds or memory may be released later
+			cn.retryLater(err)
+		default:
+			cn.state = connectorDisconnected
+			cn.fail(err)
+		}
+		return
+	}
+
+	err = syscall.Connect(socketFD, cn.serverAddr)
+
+	errno, _ := err.(syscall.Errno)
+	switch errno {
+	case 0, syscall.EINPROGRESS, syscall.EINTR, syscall.EISCONN:
+		cn.connecting(socketFD)
+	case syscall.EAGAIN, syscall.EADDRINUSE, syscall.EADDRNOTAVAIL, syscall.ECONNREFUSED,
+		syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ETIMEDOUT, syscall.ENOENT:
+		syscall.Close(socketFD)
+		cn.retryLater(err)
+	default:
+		// EACCES, EPERM, EAFNOSUPPORT... retrying does not help
+		syscall.Close(socketFD)
+		cn.state = connectorDisconnected
+		cn.fail(err)
+	}
+}
+
+// watch the socket, it becomes writable when connect(2) completes or fails
+func (cn *tcpConnector) connecting(socketFD int) {
+	cn.state = connectorConnecting
+	cn.socketChannel = eventloop.NewChannel(socketFD)
+	cn.socketChannel.SetWriteCallback(cn.handleWrite)
+	cn.socketChannel.EnableWrite()
+	cn.loop.UpdateChannelInLoopGoroutine(cn.socketChannel)
+}
+
+// the socket fd will be handed to tcpConnection, which creates its own channel
+func (cn *tcpConnector) removeChannel() int {
+	socketFD := cn.socketChannel.GetFD()
+	cn.loop.RemoveChannelInLoopGoroutine(cn.socketChannel)
+	cn.socketChannel = nil
+	return socketFD
+}
+
+func (cn *tcpConnector) handleWrite() {
+	if cn.state != connectorConnecting {
+		return
+	}
+
+	socketFD := cn.removeChannel()
+	soErr, err := syscall.GetsockoptInt(socketFD, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && soErr != 0 {
+		err = syscall.Errno(soErr)
+	}
+	if err == nil && sockaddrFamily(cn.serverAddr) != syscall.AF_UNIX && isSelfConnect(socketFD) {
+		err = syscall.ECONNREFUSED
+	}
+	if err != nil {
+		syscall.Close(socketFD)
+		cn.retryLater(err)
+		return
+	}
+
+	if !cn.connect {
+		cn.state = connectorDisconnected
+		syscall.Close(socketFD)
+		return
+	}
+	cn.state = connectorConnected
+	cn.retryDelay = cn.initialDelay
+	cn.retries = 0
+	cn.newConnectionCallback(socketFD, addrPortFromSockaddr(cn.serverAddr))
+}
+
+func (cn *tcpConnector) retryLater(err error) {
+	cn.state = connectorDisconnected
+	if !cn.connect {
+		return
+	}
+
+	if !cn.retry || (cn.maxRetries != 0 && cn.retries >= cn.maxRetries) {
+		cn.fail(err)
+		return
+	}
+
+	cn.retries++
+	cn.connectAfter(cn.retryDelay)
+
+	cn.retryDelay *= 2
+	if cn.retryDelay > cn.maxDelay {
+		cn.retryDelay = cn.maxDelay
+	}
+}
+
+func (cn *tcpConnector) connectAfter(d time.Duration) {
+	cn.retryTimerID = cn.loop.RunAt(time.Now().Add(d), 0, func(timerID int) {
+		cn.retryTimerID = 0
+		if cn.connect {
+			cn.doConnect()
+		}
+	})
+}
+
+func (cn *tcpConnector) fail(err error) {
+	if cn.errorCallback != nil {
+		cn.errorCallback(err)
+	}
+}
+
+// connecting to a local port in the ephemeral range may connect the socket to itself
+func isSelfConnect(socketFD int) bool {
+	local, err := syscall.Getsockname(socketFD)
+	if err != nil {
+		return false
+	}
+	peer, err := syscall.Getpeername(socketFD)
+	if err != nil {
+		return false
+	}
+
+	return addrPortFromSockaddr(local) == addrPortFromSockaddr(peer)
+}
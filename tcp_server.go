@@ -1,22 +1,107 @@
 package goreactor
 
 import (
+	"context"
+	"errors"
 	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/markity/go-reactor/pkg/codec"
 	eventloop "github.com/markity/go-reactor/pkg/event_loop"
 )
 
+// returned by TCPServer.Shutdown if it is called again
+var ErrServerShutdown = errors.New("goreactor: server is already shut down")
+
 type TCPServer interface {
 	SetConnectionCallback(f ConnectedCallbackFunc)
 	SetMessageCallback(f MessageCallbackFunc)
+
+	// replaces the message callback, f receives whole frames split by dec, see
+	// NewFrameMessageCallback
+	SetFrameCallback(dec codec.Decoder, maxFrameSize int, f FrameCallbackFunc)
+
+	// only be used for IPv6 listen address, must be called before Start, see
+	// IPV6_V6ONLY in ipv6(7). returns error if the listen address is IPv4
+	SetIPv6Only(v6only bool) error
+
+	// accept(2) errors are reported to f, for example EMFILE when fds are exhausted,
+	// must be called before Start. the callback is called in acceptor loop goroutines
+	SetAcceptErrorCallback(f AcceptErrorCallbackFunc)
+
+	// accept at most n connections each time the listen socket is readable, the
+	// default is 64, must be called before Start
+	SetMaxAcceptsPerWakeup(n int)
+
+	// water levels of new connections, see TCPConnection.SetHighWaterLevel and
+	// TCPConnection.SetLowWaterLevel, must be called before Start
+	SetDefaultWaterLevels(high int, low int)
+
+	// output buffer limit of new connections, see TCPConnection.SetMaxOutputBufferSize,
+	// must be called before Start
+	SetDefaultMaxOutputBufferSize(n int, policy OutputOverflowPolicy)
+
+	// idle timeout of new connections, see TCPConnection.SetIdleTimeout, must be
+	// called before Start
+	SetDefaultIdleTimeout(d time.Duration)
+
+	// register connections with EPOLLET, they read and write until EAGAIN in each
+	// wakeup, and the write interest is never modified by epoll_ctl. the default
+	// is level-triggered, must be called before Start
+	SetEdgeTriggered(et bool)
+
+	// new connections must start with a PROXY protocol v1 or v2 header sent by a
+	// proxy like HAProxy, it is consumed before the connected callback is called,
+	// and the addresses of the connection are overridden by it, see
+	// TCPConnection.GetProxyHeader. connections whose header is invalid or is not
+	// received in headerTimeout are closed with DisconnectProxyHeaderError.
+	// 0 disables it, the default is 0, must be called before Start
+	SetProxyProtocol(headerTimeout time.Duration)
+
+	// token bucket limits shared by the connections of each remote IP, they work
+	// with the limits of each connection, see TCPConnection.SetReadLimit. the
+	// address of the PROXY protocol header is used if it is received. must be
+	// called before Start
+	SetPerIPReadLimit(bytesPerSecond int, burst int)
+	SetPerIPWriteLimit(bytesPerSecond int, burst int)
+
+	// each remote IP can open perSecond new connections per second with bursts of
+	// burst, connections beyond it are closed right after they are accepted and
+	// counted by ServerStats.Rejected. the address of the socket is used since
+	// the PROXY protocol header is not received yet. must be called before Start
+	SetConnectionRateLimit(perSecond int, burst int)
+
+	// counters of the server and its loops, it can be called in any goroutine
+	Stats() ServerStats
+
 	Start() error
+
+	// the address the server listens at, the port is chosen by the kernel if it
+	// is 0. must be called after Start, it is the zero value for unix sockets
+	GetListenAddrPort() netip.AddrPort
+
 	GetAllLoops() (baseLoop eventloop.EventLoop, others []eventloop.EventLoop)
+
+	// stop accepting, shutdown write of all connections and wait until their
+	// output buffers are flushed and the peers close the connections, or ctx is
+	// done, ctx.Err() is returned in the latter case. then the connections still
+	// open are closed with DisconnectServerShutdown and the working loops are
+	// stopped, Shutdown returns after their DoOnStop hooks ran, the base loop is
+	// not stopped.
+	// it blocks, so it must not be called in any loop goroutine of the server.
+	// calling it again returns ErrServerShutdown
+	Shutdown(ctx context.Context) error
 }
 
 type tcpServer struct {
 	loop eventloop.EventLoop
 
-	acceptor *tcpAcceptor
+	// one acceptor on the base loop, or one acceptor on each working loop in
+	// reuse port mode, see NewReusePortTCPServer
+	acceptors []*tcpAcceptor
 
 	// only be used to prevent double start
 	started bool
@@ -24,9 +109,44 @@ type tcpServer struct {
 	connectedCallback ConnectedCallbackFunc
 	msgCallback       MessageCallbackFunc
 
+	// defaults of new connections
+	highWaterLevel      int
+	lowWaterLevel       int
+	maxOutputBufferSize int
+	overflowPolicy      OutputOverflowPolicy
+	idleTimeout         time.Duration
+	edgeTriggered       bool
+	proxyHeaderTimeout  time.Duration
+
+	// nil means no limit, see rate_limit.go
+	ipLimiter     *ipLimiter
+	acceptLimiter *acceptLimiter
+
 	evloopPoll *eventloopGoroutinePoll
 
 	loadBalanceStrategy LoadBalanceStrategy
+
+	// mu protects fields below, connections are added in base loop goroutine and
+	// removed in their own loop goroutines
+	mu    sync.Mutex
+	conns map[*tcpConnection]struct{}
+
+	// set by Shutdown, new connections are closed after that
+	shuttingDown bool
+
+	// closed when shuttingDown is set and there is no connection
+	allClosed chan struct{}
+
+	// connections whose output is not flushed yet, allDrained is closed when it
+	// drops to 0, see Shutdown
+	undrained  int
+	allDrained chan struct{}
+
+	// see Stats, closed is indexed by DisconnectReason
+	accepted     atomic.Uint64
+	rejected     atomic.Uint64
+	closed       [DisconnectProxyHeaderError + 1]atomic.Uint64
+	loopCounters []*loopCounters
 }
 
 func (server *tcpServer) SetConnectionCallback(f ConnectedCallbackFunc) {
@@ -37,14 +157,114 @@ func (server *tcpServer) SetMessageCallback(f MessageCallbackFunc) {
 	server.msgCallback = f
 }
 
+func (server *tcpServer) SetFrameCallback(dec codec.Decoder, maxFrameSize int, f FrameCallbackFunc) {
+	server.msgCallback = NewFrameMessageCallback(dec, maxFrameSize, f)
+}
+
+func (server *tcpServer) SetIPv6Only(v6only bool) error {
+	for _, acceptor := range server.acceptors {
+		err := acceptor.SetIPv6Only(v6only)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *tcpServer) SetAcceptErrorCallback(f AcceptErrorCallbackFunc) {
+	for _, acceptor := range server.acceptors {
+		acceptor.SetAcceptErrorCallback(f)
+	}
+}
+
+func (server *tcpServer) SetMaxAcceptsPerWakeup(n int) {
+	for _, acceptor := range server.acceptors {
+		acceptor.SetMaxAcceptsPerWakeup(n)
+	}
+}
+
+func (server *tcpServer) SetDefaultWaterLevels(high int, low int) {
+	if high < 0 || low < 0 {
+		panic("negative water level")
+	}
+	server.highWaterLevel = high
+	server.lowWaterLevel = low
+}
+
+func (server *tcpServer) SetDefaultMaxOutputBufferSize(n int, policy OutputOverflowPolicy) {
+	if n < 0 {
+		panic(n)
+	}
+	if policy != OutputOverflowClose && policy != OutputOverflowReject {
+		panic(policy)
+	}
+	server.maxOutputBufferSize = n
+	server.overflowPolicy = policy
+}
+
+func (server *tcpServer) SetDefaultIdleTimeout(d time.Duration) {
+	if d < 0 {
+		panic(d)
+	}
+	server.idleTimeout = d
+}
+
+func (server *tcpServer) SetEdgeTriggered(et bool) {
+	server.edgeTriggered = et
+}
+
+func (server *tcpServer) SetProxyProtocol(headerTimeout time.Duration) {
+	if headerTimeout < 0 {
+		panic(headerTimeout)
+	}
+	server.proxyHeaderTimeout = headerTimeout
+}
+
+func (server *tcpServer) SetPerIPReadLimit(bytesPerSecond int, burst int) {
+	// validates them
+	newTokenBucket(bytesPerSecond, burst)
+	server.ipLimiterOrNew().readRate = bytesPerSecond
+	server.ipLimiter.readBurst = burst
+}
+
+func (server *tcpServer) SetPerIPWriteLimit(bytesPerSecond int, burst int) {
+	newTokenBucket(bytesPerSecond, burst)
+	server.ipLimiterOrNew().writeRate = bytesPerSecond
+	server.ipLimiter.writeBurst = burst
+}
+
+func (server *tcpServer) ipLimiterOrNew() *ipLimiter {
+	if server.ipLimiter == nil {
+		server.ipLimiter = &ipLimiter{buckets: make(map[netip.Addr]*ipBuckets)}
+	}
+	return server.ipLimiter
+}
+
+func (server *tcpServer) SetConnectionRateLimit(perSecond int, burst int) {
+	newTokenBucket(perSecond, burst)
+	server.acceptLimiter = &acceptLimiter{
+		rate:    perSecond,
+		burst:   burst,
+		buckets: make(map[netip.Addr]*tokenBucket),
+		sweepAt: 1024,
+	}
+}
+
 func (server *tcpServer) Start() error {
 	if server.started {
 		panic("already started")
 	}
 
-	err := server.acceptor.Listen()
-	if err != nil {
-		return err
+	// working loops are not running, it is safe to register acceptors into them
+	for i, acceptor := range server.acceptors {
+		// in reuse port mode, all acceptors listen at the port chosen for the first
+		if i != 0 {
+			acceptor.listenAddr = server.acceptors[0].listenAddr
+		}
+		err := acceptor.Listen()
+		if err != nil {
+			return err
+		}
 	}
 
 	server.evloopPoll.start()
@@ -54,6 +274,13 @@ func (server *tcpServer) Start() error {
 	return nil
 }
 
+func (server *tcpServer) GetListenAddrPort() netip.AddrPort {
+	if !server.started {
+		panic("not started yet")
+	}
+	return addrPortFromSockaddr(server.acceptors[0].listenAddr)
+}
+
 func (server *tcpServer) GetAllLoops() (baseLoop eventloop.EventLoop,
 	others []eventloop.EventLoop) {
 	cpy := make([]eventloop.EventLoop, len(server.evloopPoll.loops))
@@ -61,19 +288,162 @@ func (server *tcpServer) GetAllLoops() (baseLoop eventloop.EventLoop,
 	return server.loop, cpy
 }
 
+func (server *tcpServer) Shutdown(ctx context.Context) error {
+	if !server.started {
+		panic("not started yet")
+	}
+
+	server.mu.Lock()
+	if server.shuttingDown {
+		server.mu.Unlock()
+		return ErrServerShutdown
+	}
+	// connections accepted after it are closed right now, see newConnectionOnLoop
+	server.shuttingDown = true
+	conns := make([]*tcpConnection, 0, len(server.conns))
+	for conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.undrained = len(conns)
+	if server.undrained == 0 {
+		close(server.allDrained)
+	}
+	server.checkAllClosedLocked()
+	server.mu.Unlock()
+
+	// the acceptors can only be closed in their loop goroutines. wait for them, so
+	// no connection is being accepted when the working loops are stopped
+	closed := make(chan struct{}, len(server.acceptors))
+	for _, acceptor := range server.acceptors {
+		acceptor := acceptor
+		acceptor.loop.RunInLoop(func() {
+			acceptor.close()
+			closed <- struct{}{}
+		})
+	}
+	for range server.acceptors {
+		<-closed
+	}
+
+	// the output buffers are flushed before shutdown write, see ShutdownWrite
+	for _, conn := range conns {
+		conn.shutdownWriteAndNotify(server.onConnectionDrained)
+	}
+
+	// peers close their connections after they read the responses and the FIN,
+	// closing them first may send RST and the peers may lose the responses
+	var err error
+	select {
+	case <-server.allDrained:
+		select {
+		case <-server.allClosed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// connections can not be left on stopped loops
+	if err != nil {
+		server.mu.Lock()
+		conns = conns[:0]
+		for conn := range server.conns {
+			conns = append(conns, conn)
+		}
+		server.mu.Unlock()
+		for _, conn := range conns {
+			conn.closeForShutdown()
+		}
+		<-server.allClosed
+	}
+
+	server.evloopPoll.stop()
+
+	return err
+}
+
+// be called in loop goroutines of connections, see Shutdown
+func (server *tcpServer) onConnectionDrained() {
+	server.mu.Lock()
+	server.undrained--
+	if server.undrained == 0 {
+		close(server.allDrained)
+	}
+	server.mu.Unlock()
+}
+
 func (server *tcpServer) onNewConnection(socketfd int, peerAddr netip.AddrPort) {
-	loop := server.evloopPoll.getNext()
+	server.newConnectionOnLoop(server.evloopPoll.getNext(), socketfd, peerAddr)
+}
+
+// if loop is the loop of the acceptor, establishConn is called right now without
+// queueing a functor
+func (server *tcpServer) newConnectionOnLoop(loop eventloop.EventLoop, socketfd int, peerAddr netip.AddrPort) {
+	// unix socket connections have no address
+	if server.acceptLimiter != nil && peerAddr.IsValid() && !server.acceptLimiter.allow(peerAddr.Addr()) {
+		server.rejected.Add(1)
+		syscall.Close(socketfd)
+		return
+	}
 
 	conn := newConnection(loop, socketfd, peerAddr)
 	conn.setConnectedCallback(server.connectedCallback)
 	conn.setMessageCallback(server.msgCallback)
+	conn.setCloseCallback(server.removeConnection)
+	conn.highWaterLevel = server.highWaterLevel
+	conn.lowWaterLevel = server.lowWaterLevel
+	conn.maxOutputBufferSize = server.maxOutputBufferSize
+	conn.overflowPolicy = server.overflowPolicy
+	conn.idleTimeout = server.idleTimeout
+	conn.proxyHeaderTimeout = server.proxyHeaderTimeout
+	conn.ipLimiter = server.ipLimiter
+	conn.socketChannel.SetEdgeTriggered(server.edgeTriggered)
+	conn.loopCounters = server.countersOf(loop)
 
+	// registered in the loop goroutine, so the functors of Shutdown for conn run
+	// after it is established
 	loop.RunInLoop(func() {
+		server.mu.Lock()
+		if server.shuttingDown {
+			server.mu.Unlock()
+			syscall.Close(socketfd)
+			return
+		}
+		conn.loopCounters.active.Add(1)
+		server.accepted.Add(1)
+		server.conns[conn] = struct{}{}
+		server.mu.Unlock()
+
 		conn.establishConn()
 	})
+}
+
+// be called in the loop goroutine of conn after it is closed
+func (server *tcpServer) removeConnection(conn *tcpConnection) {
+	conn.loopCounters.active.Add(-1)
+	server.closed[conn.disconnectReason].Add(1)
 
+	server.mu.Lock()
+	delete(server.conns, conn)
+	server.checkAllClosedLocked()
+	server.mu.Unlock()
 }
 
+// server.mu must be held
+func (server *tcpServer) checkAllClosedLocked() {
+	if !server.shuttingDown || len(server.conns) != 0 {
+		return
+	}
+
+	select {
+	case <-server.allClosed:
+	default:
+		close(server.allClosed)
+	}
+}
+
+// addrPort can be IPv4 or IPv6, for example "127.0.0.1:8000" or "[::]:8000"
 // if numWorkingThread is 0, all channel will run on single loop
 func NewTCPServer(loop eventloop.EventLoop, addrPort string,
 	numWorkingThread int, strategy LoadBalanceStrategy) TCPServer {
@@ -82,22 +452,70 @@ func NewTCPServer(loop eventloop.EventLoop, addrPort string,
 		panic(err)
 	}
 
+	return newServer(loop, newTCPAcceptor(loop, sockaddrFromAddrPort(listenAt), 1024),
+		numWorkingThread, strategy)
+}
+
+// like NewTCPServer, but each working loop has its own listen socket bound to
+// addrPort with SO_REUSEPORT, the kernel distributes new connections across them
+// by the hash of the 4-tuple, and the connections stay on the loop which accepts
+// them. if numWorkingThread is 0, it is the same as NewTCPServer
+func NewReusePortTCPServer(loop eventloop.EventLoop, addrPort string, numWorkingThread int) TCPServer {
+	listenAt, err := netip.ParseAddrPort(addrPort)
+	if err != nil {
+		panic(err)
+	}
+
+	if numWorkingThread == 0 {
+		return newServer(loop, newTCPAcceptor(loop, sockaddrFromAddrPort(listenAt), 1024),
+			numWorkingThread, RoundRobin())
+	}
+
+	server := newServer(loop, nil, numWorkingThread, RoundRobin())
+	for _, workingLoop := range server.evloopPoll.loops {
+		workingLoop := workingLoop
+		acceptor := newTCPAcceptor(workingLoop, sockaddrFromAddrPort(listenAt), 1024)
+		acceptor.SetNewConnectionCallback(func(socketfd int, peerAddr netip.AddrPort) {
+			server.newConnectionOnLoop(workingLoop, socketfd, peerAddr)
+		})
+		server.acceptors = append(server.acceptors, acceptor)
+	}
+
+	return server
+}
+
+// acceptor can be nil, acceptors are added by the caller in this case
+func newServer(loop eventloop.EventLoop, acceptor *tcpAcceptor,
+	numWorkingThread int, strategy LoadBalanceStrategy) *tcpServer {
 	if numWorkingThread < 0 {
 		panic(numWorkingThread)
 	}
 
-	acceptor := newTCPAcceptor(loop, listenAt, 1024)
 	server := &tcpServer{
 		loop:                loop,
-		acceptor:            acceptor,
 		started:             false,
 		connectedCallback:   defaultConnectedCallback,
 		msgCallback:         defaultMessageCallback,
 		evloopPoll:          newEventloopGoroutinePoll(loop, numWorkingThread, strategy),
 		loadBalanceStrategy: strategy,
+		conns:               make(map[*tcpConnection]struct{}),
+		allClosed:           make(chan struct{}),
+		allDrained:          make(chan struct{}),
 	}
 
-	acceptor.SetNewConnectionCallback(server.onNewConnection)
+	// connections run on the base loop if there is no working loop
+	loops := server.evloopPoll.loops
+	if numWorkingThread == 0 {
+		loops = []eventloop.EventLoop{loop}
+	}
+	for _, l := range loops {
+		server.loopCounters = append(server.loopCounters, &loopCounters{loop: l})
+	}
+
+	if acceptor != nil {
+		acceptor.SetNewConnectionCallback(server.onNewConnection)
+		server.acceptors = append(server.acceptors, acceptor)
+	}
 
 	return server
 }
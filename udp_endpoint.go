@@ -0,0 +1,283 @@
+package goreactor
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+const (
+	// max datagrams received by one recvmmsg(2) call
+	udpRecvBatchSize = 16
+
+	// large enough for any UDP payload
+	udpMaxDatagramSize = 65536
+)
+
+type UDPEndpoint interface {
+	// payload is only valid before the callback returns, copy it if needed
+	SetMessageCallback(f UDPMessageCallbackFunc)
+
+	// bind the socket and register it into loop
+	Start() error
+
+	// send a datagram, it is queued if the socket buffer is full. safe to call
+	// in any goroutine, bs is referenced until it is sent, so it must not be
+	// modified after calling
+	SendTo(bs []byte, to netip.AddrPort)
+
+	// remove the socket from loop and close it, queued datagrams are dropped
+	Close()
+
+	GetLocalAddrPort() netip.AddrPort
+	GetEventLoop() eventloop.EventLoop
+	GetFD() int
+}
+
+// mmsghdr of recvmmsg(2), golang pads it to the alignment of Msghdr
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+type pendingDatagram struct {
+	data []byte
+	to   syscall.Sockaddr
+}
+
+type udpEndpoint struct {
+	loop eventloop.EventLoop
+
+	// be used to prevent double start, Start may be called in any goroutine
+	started atomic.Bool
+
+	// socket family, AF_INET or AF_INET6
+	family int
+
+	bindAddr netip.AddrPort
+
+	socketChannel eventloop.Channel
+
+	messageCallback UDPMessageCallbackFunc
+
+	// datagrams waiting for the socket to be writable
+	pending []pendingDatagram
+
+	// buffers for recvmmsg, each message has its own iovec and name
+	msgs  [udpRecvBatchSize]mmsghdr
+	iovs  [udpRecvBatchSize]syscall.Iovec
+	names [udpRecvBatchSize]syscall.RawSockaddrAny
+	bufs  [udpRecvBatchSize][]byte
+}
+
+func newUDPEndpoint(loop eventloop.EventLoop, bindAddr netip.AddrPort, reusePort bool) *udpEndpoint {
+	sa := sockaddrFromAddrPort(bindAddr)
+	family := sockaddrFamily(sa)
+	socketFD, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	if reusePort {
+		// 15 means SO_REUSEPORT
+		err = syscall.SetsockoptInt(socketFD, syscall.SOL_SOCKET, 15, 1)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	c := eventloop.NewChannel(socketFD)
+	ep := &udpEndpoint{
+		loop:            loop,
+		family:          family,
+		bindAddr:        bindAddr,
+		socketChannel:   c,
+		messageCallback: defaultUDPMessageCallback,
+	}
+	for i := range ep.msgs {
+		ep.bufs[i] = make([]byte, udpMaxDatagramSize)
+		ep.iovs[i].Base = &ep.bufs[i][0]
+		ep.iovs[i].SetLen(udpMaxDatagramSize)
+	}
+	c.SetReadCallback(ep.handleRead)
+	c.SetWriteCallback(ep.handleWrite)
+
+	return ep
+}
+
+// bind to addrPort, for example "0.0.0.0:9000" or "[::]:9000", the endpoint
+// runs on loop
+func NewUDPEndpoint(loop eventloop.EventLoop, addrPort string) UDPEndpoint {
+	bindAddr, err := netip.ParseAddrPort(addrPort)
+	if err != nil {
+		panic(err)
+	}
+
+	return newUDPEndpoint(loop, bindAddr, false)
+}
+
+func (ep *udpEndpoint) SetMessageCallback(f UDPMessageCallbackFunc) {
+	ep.messageCallback = f
+}
+
+func (ep *udpEndpoint) Start() error {
+	if ep.started.Load() {
+		panic("already started")
+	}
+
+	err := syscall.Bind(ep.socketChannel.GetFD(), sockaddrFromAddrPort(ep.bindAddr))
+	if err != nil {
+		return err
+	}
+
+	ep.started.Store(true)
+	ep.loop.RunInLoop(func() {
+		ep.socketChannel.EnableRead()
+		ep.loop.UpdateChannelInLoopGoroutine(ep.socketChannel)
+	})
+	return nil
+}
+
+func (ep *udpEndpoint) SendTo(bs []byte, to netip.AddrPort) {
+	ep.loop.RunInLoop(func() {
+		if !ep.started.Load() {
+			return
+		}
+
+		sa := ep.sockaddrOf(to)
+		if len(ep.pending) == 0 {
+			err := syscall.Sendto(ep.socketChannel.GetFD(), bs, syscall.MSG_DONTWAIT, sa)
+			if err != syscall.EAGAIN {
+				// datagrams are unreliable, other errors just drop the datagram
+				return
+			}
+		}
+
+		// the caller does not modify bs, so it is queued without copying
+		ep.pending = append(ep.pending, pendingDatagram{data: bs, to: sa})
+		if ep.socketChannel.EnableWrite() {
+			ep.loop.UpdateChannelInLoopGoroutine(ep.socketChannel)
+		}
+	})
+}
+
+func (ep *udpEndpoint) Close() {
+	ep.loop.RunInLoop(func() {
+		if !ep.started.Load() {
+			return
+		}
+
+		ep.started.Store(false)
+		ep.pending = nil
+		ep.loop.RemoveChannelInLoopGoroutine(ep.socketChannel)
+		syscall.Close(ep.socketChannel.GetFD())
+	})
+}
+
+func (ep *udpEndpoint) GetLocalAddrPort() netip.AddrPort {
+	sa, err := syscall.Getsockname(ep.socketChannel.GetFD())
+	if err != nil {
+		return ep.bindAddr
+	}
+	return addrPortFromSockaddr(sa)
+}
+
+func (ep *udpEndpoint) GetEventLoop() eventloop.EventLoop {
+	return ep.loop
+}
+
+func (ep *udpEndpoint) GetFD() int {
+	return ep.socketChannel.GetFD()
+}
+
+// an IPv6 socket sends to IPv4 peers with IPv4-mapped addresses
+func (ep *udpEndpoint) sockaddrOf(to netip.AddrPort) syscall.Sockaddr {
+	if ep.family == syscall.AF_INET6 && to.Addr().Is4() {
+		to = netip.AddrPortFrom(netip.AddrFrom16(to.Addr().As16()), to.Port())
+	}
+	return sockaddrFromAddrPort(to)
+}
+
+func (ep *udpEndpoint) handleRead() {
+	for i := range ep.msgs {
+		ep.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&ep.names[i]))
+		ep.msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+		ep.msgs[i].hdr.Iov = &ep.iovs[i]
+		ep.msgs[i].hdr.Iovlen = 1
+		ep.msgs[i].hdr.Flags = 0
+		ep.msgs[i].len = 0
+	}
+
+	var n uintptr
+	for {
+		var errno syscall.Errno
+		n, _, errno = syscall.Syscall6(syscall.SYS_RECVMMSG, uintptr(ep.socketChannel.GetFD()),
+			uintptr(unsafe.Pointer(&ep.msgs[0])), udpRecvBatchSize, syscall.MSG_DONTWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// EAGAIN, or ECONNREFUSED caused by ICMP of a previous SendTo
+			return
+		}
+		break
+	}
+
+	for i := 0; i < int(n); i++ {
+		// the datagram is larger than the buffer, drop it
+		if ep.msgs[i].hdr.Flags&syscall.MSG_TRUNC != 0 {
+			continue
+		}
+
+		from := addrPortFromRawSockaddr(&ep.names[i])
+		ep.messageCallback(ep, ep.bufs[i][:ep.msgs[i].len], from)
+
+		// the callback may close the endpoint
+		if !ep.started.Load() {
+			return
+		}
+	}
+}
+
+func (ep *udpEndpoint) handleWrite() {
+	for len(ep.pending) > 0 {
+		d := ep.pending[0]
+		err := syscall.Sendto(ep.socketChannel.GetFD(), d.data, syscall.MSG_DONTWAIT, d.to)
+		if err == syscall.EAGAIN {
+			return
+		}
+		ep.pending[0] = pendingDatagram{}
+		ep.pending = ep.pending[1:]
+	}
+
+	ep.pending = nil
+	if ep.socketChannel.DisableWrite() {
+		ep.loop.UpdateChannelInLoopGoroutine(ep.socketChannel)
+	}
+}
+
+// converts the name filled by recvmmsg(2)
+func addrPortFromRawSockaddr(rsa *syscall.RawSockaddrAny) netip.AddrPort {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return addrPortFromSockaddr(&syscall.SockaddrInet4{
+			Port: int(p[0])<<8 + int(p[1]),
+			Addr: pp.Addr,
+		})
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return addrPortFromSockaddr(&syscall.SockaddrInet6{
+			Port:   int(p[0])<<8 + int(p[1]),
+			ZoneId: pp.Scope_id,
+			Addr:   pp.Addr,
+		})
+	}
+
+	return netip.AddrPort{}
+}
@@ -0,0 +1,95 @@
+package goreactor
+
+import (
+	"net/netip"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+type UDPServer interface {
+	SetMessageCallback(f UDPMessageCallbackFunc)
+	Start() error
+	GetEndpoints() []UDPEndpoint
+	GetAllLoops() (baseLoop eventloop.EventLoop, others []eventloop.EventLoop)
+}
+
+type udpServer struct {
+	loop eventloop.EventLoop
+
+	// only be used to prevent double start
+	started bool
+
+	// one endpoint for each loop, they share one port by SO_REUSEPORT
+	endpoints []*udpEndpoint
+
+	evloopPoll *eventloopGoroutinePoll
+}
+
+func (server *udpServer) SetMessageCallback(f UDPMessageCallbackFunc) {
+	for _, ep := range server.endpoints {
+		ep.SetMessageCallback(f)
+	}
+}
+
+func (server *udpServer) Start() error {
+	if server.started {
+		panic("already started")
+	}
+
+	for _, ep := range server.endpoints {
+		err := ep.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	server.evloopPoll.start()
+
+	server.started = true
+
+	return nil
+}
+
+func (server *udpServer) GetEndpoints() []UDPEndpoint {
+	eps := make([]UDPEndpoint, 0, len(server.endpoints))
+	for _, ep := range server.endpoints {
+		eps = append(eps, ep)
+	}
+	return eps
+}
+
+func (server *udpServer) GetAllLoops() (baseLoop eventloop.EventLoop,
+	others []eventloop.EventLoop) {
+	cpy := make([]eventloop.EventLoop, len(server.evloopPoll.loops))
+	copy(cpy, server.evloopPoll.loops)
+	return server.loop, cpy
+}
+
+// if numWorkingThread is 0, there is one endpoint on loop, otherwise each working
+// loop has its own socket bound to addrPort with SO_REUSEPORT, and the kernel
+// spreads datagrams across them by the hash of the source address
+func NewUDPServer(loop eventloop.EventLoop, addrPort string, numWorkingThread int) UDPServer {
+	bindAddr, err := netip.ParseAddrPort(addrPort)
+	if err != nil {
+		panic(err)
+	}
+
+	if numWorkingThread < 0 {
+		panic(numWorkingThread)
+	}
+
+	server := &udpServer{
+		loop:       loop,
+		evloopPoll: newEventloopGoroutinePoll(loop, numWorkingThread, RoundRobin()),
+	}
+
+	if numWorkingThread == 0 {
+		server.endpoints = append(server.endpoints, newUDPEndpoint(loop, bindAddr, false))
+	} else {
+		for _, l := range server.evloopPoll.loops {
+			server.endpoints = append(server.endpoints, newUDPEndpoint(l, bindAddr, true))
+		}
+	}
+
+	return server
+}
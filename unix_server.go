@@ -0,0 +1,76 @@
+package goreactor
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+
+	eventloop "github.com/markity/go-reactor/pkg/event_loop"
+)
+
+// listen on a unix domain stream socket, the connections are the same as tcp
+// connections except that GetRemoteAddrPort returns the zero value and
+// GetPeerCredentials is available. if path starts with '@', the socket is
+// created in the abstract namespace and no file is created, see unix(7).
+// a stale socket file left by a dead process is removed when Start is called,
+// perm is the file mode of the socket file, 0 means decided by umask
+func NewUnixServer(loop eventloop.EventLoop, path string, perm os.FileMode,
+	numWorkingThread int, strategy LoadBalanceStrategy) TCPServer {
+	if path == "" {
+		panic("empty unix socket path")
+	}
+
+	acceptor := newTCPAcceptor(loop, &syscall.SockaddrUnix{Name: path}, 1024)
+	acceptor.unixPerm = perm
+	return newServer(loop, acceptor, numWorkingThread, strategy)
+}
+
+// connect to a unix domain stream socket, see NewUnixServer for path
+func NewUnixClient(loop eventloop.EventLoop, path string, opts TCPClientOptions) TCPClient {
+	if path == "" {
+		panic("empty unix socket path")
+	}
+
+	return newClient(loop, &syscall.SockaddrUnix{Name: path}, opts)
+}
+
+func isAbstractUnixSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// remove the socket file if no one is listening on it, returns EADDRINUSE if
+// the file is in use or it is not a socket file
+func removeStaleUnixSocket(path string) error {
+	if isAbstractUnixSocket(path) {
+		return nil
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return syscall.EADDRINUSE
+	}
+
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	err = syscall.Connect(fd, &syscall.SockaddrUnix{Name: path})
+	if err == nil {
+		return syscall.EADDRINUSE
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+
+	return os.Remove(path)
+}